@@ -0,0 +1,162 @@
+package go_epoll
+
+import (
+	"net/netip"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// CIDR规则
+type cidrRules struct {
+	allow []netip.Prefix //白名单，不为空时，只允许名单内的IP
+	deny  []netip.Prefix //黑名单
+}
+
+// 准入控制，在Accept时，创建连接之前，按来源IP进行检查
+type Admission struct {
+	maxConnsPerIP int                         //每个IP最大连接数，0表示不限制
+	ipRate        float64                     //每个IP每秒允许新建的连接数，0表示不限制
+	ipBurst       int                         //每个IP允许的突发连接数
+	rules         atomic.Pointer[cidrRules]   //CIDR规则，支持热更新
+	ipConns       map[netip.Addr]int          //每个IP当前的连接数
+	ipBuckets     map[netip.Addr]*TokenBucket //每个IP的令牌桶
+	lastPrune     time.Time                   //上次清理令牌桶的时间
+	lock          sync.Mutex                  //锁
+}
+
+func NewAdmission() *Admission {
+	a := &Admission{
+		ipConns:   make(map[netip.Addr]int),
+		ipBuckets: make(map[netip.Addr]*TokenBucket),
+		lastPrune: time.Now(),
+	}
+	a.rules.Store(&cidrRules{})
+	return a
+}
+
+// 设置每个IP最大连接数，n <= 0 表示不限制
+func (a *Admission) SetMaxConnsPerIP(n int) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.maxConnsPerIP = n
+}
+
+// 设置每个IP每秒允许新建的连接数，以及允许的突发数量，rate <= 0 表示不限制
+func (a *Admission) SetIPRate(rate float64, burst int) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.ipRate = rate
+	a.ipBurst = burst
+	a.ipBuckets = make(map[netip.Addr]*TokenBucket)
+}
+
+// 设置CIDR白名单与黑名单，支持IPv4与IPv6，可在运行时调用进行热更新
+func (a *Admission) SetCIDR(allow []string, deny []string) error {
+	allowPrefixes, err := ParsePrefixes(allow)
+	if err != nil {
+		return err
+	}
+	denyPrefixes, err := ParsePrefixes(deny)
+	if err != nil {
+		return err
+	}
+	a.rules.Store(&cidrRules{
+		allow: allowPrefixes,
+		deny:  denyPrefixes,
+	})
+	return nil
+}
+
+// 检查IP是否允许连接，允许时会占用一个连接数，连接关闭时需要调用Release
+func (a *Admission) Admit(ip netip.Addr) error {
+	ip = ip.Unmap()
+
+	rules := a.rules.Load()
+	if MatchPrefixes(rules.deny, ip) {
+		return IPDenied
+	}
+	if len(rules.allow) > 0 && !MatchPrefixes(rules.allow, ip) {
+		return IPNotAllowed
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	if a.maxConnsPerIP > 0 && a.ipConns[ip] >= a.maxConnsPerIP {
+		return IPConnLimitExceeded
+	}
+
+	if a.ipRate > 0 {
+		a.pruneBuckets()
+		tb, ok := a.ipBuckets[ip]
+		if !ok {
+			tb = NewTokenBucket(a.ipRate, a.ipBurst)
+			a.ipBuckets[ip] = tb
+		}
+		if !tb.Allow() {
+			return IPRateLimitExceeded
+		}
+	}
+
+	a.ipConns[ip]++
+	return nil
+}
+
+// 释放IP占用的连接数
+func (a *Admission) Release(ip netip.Addr) {
+	ip = ip.Unmap()
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	if n, ok := a.ipConns[ip]; ok {
+		if n <= 1 {
+			delete(a.ipConns, ip)
+		} else {
+			a.ipConns[ip] = n - 1
+		}
+	}
+}
+
+// 清理已经填满的令牌桶，防止map无限增长
+func (a *Admission) pruneBuckets() {
+	if time.Since(a.lastPrune) < time.Minute {
+		return
+	}
+	a.lastPrune = time.Now()
+	for ip, tb := range a.ipBuckets {
+		if tb.Full() {
+			delete(a.ipBuckets, ip)
+		}
+	}
+}
+
+// 解析CIDR列表，单个IP会转换成/32或/128
+func ParsePrefixes(cidrs []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			addr, e := netip.ParseAddr(cidr)
+			if e != nil {
+				return nil, err
+			}
+			addr = addr.Unmap()
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+// 判断IP是否在CIDR列表中
+func MatchPrefixes(prefixes []netip.Prefix, ip netip.Addr) bool {
+	ip = ip.Unmap()
+	for _, prefix := range prefixes {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
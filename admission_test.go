@@ -0,0 +1,162 @@
+package go_epoll
+
+import (
+	"net/netip"
+	"testing"
+)
+
+func TestParsePrefixes(t *testing.T) {
+	tests := []struct {
+		name  string
+		cidrs []string
+		want  []string
+		err   bool
+	}{
+		{"cidr", []string{"10.0.0.0/8", "2001:db8::/32"}, []string{"10.0.0.0/8", "2001:db8::/32"}, false},
+		{"masked", []string{"192.168.1.7/24"}, []string{"192.168.1.0/24"}, false},
+		{"single ip", []string{"1.2.3.4", "::1"}, []string{"1.2.3.4/32", "::1/128"}, false},
+		{"mapped ip", []string{"::ffff:1.2.3.4"}, []string{"1.2.3.4/32"}, false},
+		{"empty", nil, []string{}, false},
+		{"bad ip", []string{"1.2.3"}, nil, true},
+		{"bad bits", []string{"10.0.0.0/33"}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParsePrefixes(tt.cidrs)
+			if (err != nil) != tt.err {
+				t.Fatalf("err = %v", err)
+			}
+			if tt.err {
+				return
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("prefixes = %v, want %v", got, tt.want)
+			}
+			for i, p := range got {
+				if p.String() != tt.want[i] {
+					t.Fatalf("prefixes = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestMatchPrefixes(t *testing.T) {
+	prefixes, err := ParsePrefixes([]string{"10.0.0.0/8", "192.168.1.1", "2001:db8::/32"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{"10.1.2.3", true},
+		{"11.0.0.1", false},
+		{"192.168.1.1", true},
+		{"192.168.1.2", false},
+		{"::ffff:10.0.0.1", true},
+		{"2001:db8::1", true},
+		{"2001:db9::1", false},
+	}
+	for _, tt := range tests {
+		if got := MatchPrefixes(prefixes, netip.MustParseAddr(tt.ip)); got != tt.want {
+			t.Errorf("MatchPrefixes(%s) = %v, want %v", tt.ip, got, tt.want)
+		}
+	}
+	if MatchPrefixes(nil, netip.MustParseAddr("10.0.0.1")) {
+		t.Error("empty list matched")
+	}
+}
+
+func TestAdmissionCIDR(t *testing.T) {
+	tests := []struct {
+		name  string
+		allow []string
+		deny  []string
+		ip    string
+		want  error
+	}{
+		{"no rules", nil, nil, "1.2.3.4", nil},
+		{"denied", nil, []string{"1.2.3.0/24"}, "1.2.3.4", IPDenied},
+		{"mapped denied", nil, []string{"1.2.3.4"}, "::ffff:1.2.3.4", IPDenied},
+		{"allowed", []string{"10.0.0.0/8"}, nil, "10.1.1.1", nil},
+		{"not allowed", []string{"10.0.0.0/8"}, nil, "1.2.3.4", IPNotAllowed},
+		{"deny before allow", []string{"10.0.0.0/8"}, []string{"10.1.0.0/16"}, "10.1.1.1", IPDenied},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := NewAdmission()
+			if err := a.SetCIDR(tt.allow, tt.deny); err != nil {
+				t.Fatal(err)
+			}
+			if err := a.Admit(netip.MustParseAddr(tt.ip)); err != tt.want {
+				t.Fatalf("Admit = %v, want %v", err, tt.want)
+			}
+		})
+	}
+
+	if err := NewAdmission().SetCIDR([]string{"bad"}, nil); err == nil {
+		t.Fatal("bad cidr accepted")
+	}
+}
+
+// 每个IP的连接数，Release后可以再次连接，映射的IPv4地址与IPv4地址计数相同
+func TestAdmissionAdmitRelease(t *testing.T) {
+	a := NewAdmission()
+	a.SetMaxConnsPerIP(2)
+	ip := netip.MustParseAddr("1.2.3.4")
+	mapped := netip.MustParseAddr("::ffff:1.2.3.4")
+	other := netip.MustParseAddr("5.6.7.8")
+
+	steps := []struct {
+		name    string
+		release bool
+		ip      netip.Addr
+		want    error
+	}{
+		{"first", false, ip, nil},
+		{"mapped", false, mapped, nil},
+		{"over limit", false, ip, IPConnLimitExceeded},
+		{"other ip", false, other, nil},
+		{"release mapped", true, mapped, nil},
+		{"after release", false, ip, nil},
+		{"over limit again", false, mapped, IPConnLimitExceeded},
+	}
+	for _, s := range steps {
+		if s.release {
+			a.Release(s.ip)
+			continue
+		}
+		if err := a.Admit(s.ip); err != s.want {
+			t.Fatalf("%s: Admit = %v, want %v", s.name, err, s.want)
+		}
+	}
+
+	//不限制
+	a.SetMaxConnsPerIP(0)
+	if err := a.Admit(ip); err != nil {
+		t.Fatalf("unlimited Admit = %v", err)
+	}
+	//没有占用连接数的IP释放时不做任何事
+	a.Release(netip.MustParseAddr("9.9.9.9"))
+}
+
+func TestAdmissionIPRate(t *testing.T) {
+	a := NewAdmission()
+	a.SetIPRate(1, 2)
+	ip := netip.MustParseAddr("1.2.3.4")
+	for i, want := range []error{nil, nil, IPRateLimitExceeded} {
+		if err := a.Admit(ip); err != want {
+			t.Fatalf("admit %d = %v, want %v", i, err, want)
+		}
+	}
+	//每个IP单独限速
+	if err := a.Admit(netip.MustParseAddr("5.6.7.8")); err != nil {
+		t.Fatalf("other ip = %v", err)
+	}
+	//修改速率后重新计算
+	a.SetIPRate(0, 0)
+	if err := a.Admit(ip); err != nil {
+		t.Fatalf("unlimited = %v", err)
+	}
+}
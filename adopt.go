@@ -0,0 +1,113 @@
+package go_epoll
+
+import (
+	"golang.org/x/sys/unix"
+	"net"
+	"os"
+	"syscall"
+)
+
+// 通过SyscallConn复制fd，不依赖私有字段，复制后的fd与原来的互相独立，关闭原来的不会关闭socket
+// 支持net.TCPConn、net.TCPListener、net.UnixConn、net.UnixListener、os.File等
+func DupFD(v syscall.Conn) (int, error) {
+	rc, err := v.SyscallConn()
+	if err != nil {
+		return -1, err
+	}
+	nfd := -1
+	var dupErr error
+	err = rc.Control(func(fd uintptr) {
+		nfd, dupErr = unix.FcntlInt(fd, unix.F_DUPFD_CLOEXEC, 0)
+	})
+	if err != nil {
+		return -1, err
+	}
+	if dupErr != nil {
+		return -1, dupErr
+	}
+	return nfd, nil
+}
+
+// 接管其它代码建立的连接，如HTTP Upgrade之后Hijack得到的连接，成功后c会被关闭，不能再使用，失败时c不受影响
+// 不支持tls.Conn，TLS的状态保存在crypto/tls中无法接管，可以使用WithTLSConfig
+// Hijack返回的bufio.ReadWriter中已经缓冲的数据需要调用方自己处理
+func (s *TcpServer) AdoptConn(c net.Conn) (*Conn, error) {
+	sc, ok := c.(syscall.Conn)
+	if !ok {
+		return nil, AdoptNotSupported
+	}
+	nfd, err := DupFD(sc)
+	if err != nil {
+		return nil, err
+	}
+
+	addr := s.addr
+	if ra := c.RemoteAddr(); ra != nil && ra.String() != "" {
+		addr = ra.String()
+	}
+
+	//超出连接数或者被准入控制拒绝时，addConn只关闭复制的fd，原来的连接还可以由调用方处理
+	conn, err := s.addConn(nfd, addr)
+	if err != nil {
+		return nil, err
+	}
+	//加入服务器之后再关闭原来的fd，同时从Go的netpoller中移除
+	c.Close()
+	return conn, nil
+}
+
+// 接管文件中已经建立的连接，如从父进程继承的fd，成功后f会被关闭，失败时f不受影响
+func (s *TcpServer) AdoptFile(f *os.File) (*Conn, error) {
+	nfd, err := DupFD(f)
+	if err != nil {
+		return nil, err
+	}
+
+	addr := s.addr
+	if sa, err := unix.Getpeername(nfd); err == nil {
+		if addrPort := GetAddrPortBySockAddr(sa); addrPort.IsValid() {
+			addr = addrPort.String()
+		}
+	}
+
+	conn, err := s.addConn(nfd, addr)
+	if err != nil {
+		return nil, err
+	}
+	f.Close()
+	return conn, nil
+}
+
+// 接管其它代码创建的监听，代替Listen，之后调用Serve开始接收连接，成功后ln会被关闭
+// 不支持tls.NewListener返回的监听，可以接管其中的net.Listener并使用WithTLSConfig
+func (s *TcpServer) AdoptListener(ln net.Listener) error {
+	sc, ok := ln.(syscall.Conn)
+	if !ok {
+		return AdoptNotSupported
+	}
+	nfd, err := DupFD(sc)
+	if err != nil {
+		return err
+	}
+
+	//accept循环是阻塞的，复制后的fd与原来的共用文件状态，需要在关闭原来的之后再设置
+	if ul, ok := ln.(*net.UnixListener); ok {
+		//关闭时不删除socket文件
+		ul.SetUnlinkOnClose(false)
+	}
+	s.addr = ln.Addr().String()
+	ln.Close()
+
+	if err = unix.SetNonblock(nfd, false); err != nil {
+		unix.Close(nfd)
+		return err
+	}
+	s.fd = nfd
+
+	// 预留一个文件描述符，当文件描述符耗尽时，用它来接收并关闭连接
+	s.reservedFD, err = openReservedFD()
+	if err != nil {
+		return err
+	}
+	return nil
+}
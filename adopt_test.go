@@ -0,0 +1,67 @@
+package go_epoll
+
+import (
+	"io"
+	"net"
+	"testing"
+)
+
+// 建立一对标准库的连接，返回服务端与客户端
+func stdConnPair(t *testing.T) (net.Conn, net.Conn) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	client, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+	return server, client
+}
+
+// 接管成功后原来的连接被关闭，被拒绝时原来的连接还可以使用
+func TestAdoptConn(t *testing.T) {
+	data := make(chan string, 1)
+	h := &testHandler{onData: func(c *Conn, d []byte) {
+		data <- string(d)
+		c.Write(d)
+	}}
+	s, _ := newTestServer(t, h, WithMaxConns(1, OverflowReject))
+
+	server, client := stdConnPair(t)
+	if _, err := s.AdoptConn(server); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := server.Write([]byte("x")); err == nil {
+		t.Fatal("original conn not closed")
+	}
+	client.Write([]byte("ping"))
+	if got := waitChan(t, data); got != "ping" {
+		t.Fatalf("OnData = %q", got)
+	}
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(client, buf); err != nil || string(buf) != "ping" {
+		t.Fatalf("echo = %q %v", buf, err)
+	}
+
+	server2, client2 := stdConnPair(t)
+	if _, err := s.AdoptConn(server2); err != ConnLimitExceeded {
+		t.Fatalf("AdoptConn over limit = %v", err)
+	}
+	if _, err := server2.Write([]byte("ok")); err != nil {
+		t.Fatalf("rejected conn closed: %v", err)
+	}
+	if _, err := io.ReadFull(client2, buf[:2]); err != nil || string(buf[:2]) != "ok" {
+		t.Fatalf("read = %q %v", buf[:2], err)
+	}
+}
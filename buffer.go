@@ -99,7 +99,11 @@ func (b *Buffer) ReadAll() ([]byte, error) {
 
 // 写数据
 func (b *Buffer) Write(p []byte) (n int, err error) {
+	//先把已经读过的空间腾出来，一次扩容可能不够，直到能放下为止
 	if b.tryGrow(len(p)) {
+		b.Reset()
+	}
+	for b.tryGrow(len(p)) {
 		b.grow()
 	}
 	n = copy(b.buf[b.end:], p)
@@ -131,6 +135,12 @@ func (b *Buffer) tryGrow(n int) bool {
 	return false
 }
 
+// 清空数据
+func (b *Buffer) Clear() {
+	b.start = 0
+	b.end = 0
+}
+
 // 重置
 func (b *Buffer) Reset() {
 	if b.start == 0 {
@@ -0,0 +1,592 @@
+package go_epoll
+
+import (
+	"crypto/tls"
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 反应堆配置
+type ReactorConfig struct {
+	DemultiplexerType EventDemultiplexerType `json:"demultiplexer_type"` //多路复用器类型
+	DemultiplexerSize int                    `json:"demultiplexer_size"` //多路复用器数量
+	EventSize         int                    `json:"event_size"`         //每个多路复用器一次最多返回的事件数量
+	WorkCount         int                    `json:"work_count"`         //事件工作池协程数量
+}
+
+// 服务器配置
+type ServerConfig struct {
+	ReactorConfig
+	ReadChunkSize        int              `json:"read_chunk_size"`        //每次从fd中读取的字节数
+	WriteChunkSize       int              `json:"write_chunk_size"`       //小于该值的待发送数据会合并到一起，减少writev的缓冲数量
+	BufferSize           int              `json:"buffer_size"`            //缓冲池中缓冲的初始大小
+	MaxConns             int              `json:"max_conns"`              //最大连接数，0表示不限制
+	OverflowPolicy       OverflowPolicy   `json:"overflow_policy"`        //超出最大连接数时的处理策略
+	AcceptRate           float64          `json:"accept_rate"`            //每秒最多接收的连接数，0表示不限制
+	AcceptBurst          int              `json:"accept_burst"`           //accept允许的突发数量
+	SockOptions          *SockOptions     `json:"sock_options"`           //socket选项
+	TLSConfig            *tls.Config      `json:"-"`                      //TLS配置，不为nil时开启TLS
+	TLSHandshakeTimeout  time.Duration    `json:"tls_handshake_timeout"`  //TLS握手超时时间，0表示不限制
+	ProxyProtocol        bool             `json:"proxy_protocol"`         //是否解析PROXY协议头部
+	ProxyProtocolTrusted []string         `json:"proxy_protocol_trusted"` //可信的上游CIDR，只解析来自这些地址的头部，为空表示信任所有
+	IdleTimeout          time.Duration    `json:"idle_timeout"`           //空闲超时时间，超过该时间没有读写就关闭连接，0表示不限制
+	ReadTimeout          time.Duration    `json:"read_timeout"`           //读超时时间，一条消息超过该时间没有收完就关闭连接，0表示不限制
+	WriteTimeout         time.Duration    `json:"write_timeout"`          //写超时时间，写缓冲超过该时间没有发完就关闭连接，0表示不限制
+	FlushTimeout         time.Duration    `json:"flush_timeout"`          //CloseAfterFlush的默认超时时间，调用时timeout不大于0时使用
+	TimeoutTick          time.Duration    `json:"timeout_tick"`           //超时检查的精度
+	Heartbeat            *HeartbeatConfig `json:"heartbeat"`              //心跳配置，nil表示不开启
+	WriteHighWatermark   int              `json:"write_high_watermark"`   //写缓冲高水位，超过后调用OnWriteBufferFull，0表示不限制
+	WriteLowWatermark    int              `json:"write_low_watermark"`    //写缓冲低水位，超过高水位后降到该值以下调用OnWritable，0表示高水位的一半
+	WriteFullPolicy      WriteFullPolicy  `json:"write_full_policy"`      //超过高水位时Write的处理策略
+	WriteFullTimeout     time.Duration    `json:"write_full_timeout"`     //超过高水位的时间超过该值就关闭连接，0表示不限制
+	ZeroCopyThreshold    int              `json:"zero_copy_threshold"`    //WriteZeroCopy的数据不小于该值时使用MSG_ZEROCOPY发送，0表示不使用
+	Admission            *Admission       `json:"-"`                      //准入控制
+	Logger               *Logger          `json:"-"`                      //日志，为nil时使用全局日志
+}
+
+type ReactorOption func(c *ReactorConfig)
+
+type ServerOption func(c *ServerConfig)
+
+// 默认反应堆配置，根据CPU数量设置多路复用器与工作池的大小
+func DefaultReactorConfig() ReactorConfig {
+	return ReactorConfig{
+		DemultiplexerType: EpollType,
+		DemultiplexerSize: runtime.NumCPU(),
+		EventSize:         1024,
+		WorkCount:         runtime.NumCPU() * 4,
+	}
+}
+
+// 默认服务器配置
+func DefaultServerConfig() *ServerConfig {
+	return &ServerConfig{
+		ReactorConfig:       DefaultReactorConfig(),
+		ReadChunkSize:       1024,
+		WriteChunkSize:      1024,
+		BufferSize:          1024,
+		OverflowPolicy:      OverflowReject,
+		WriteFullPolicy:     WriteFullError,
+		SockOptions:         DefaultSockOptions(),
+		TLSHandshakeTimeout: 10 * time.Second,
+		FlushTimeout:        30 * time.Second,
+		TimeoutTick:         100 * time.Millisecond,
+		ZeroCopyThreshold:   16 << 10,
+	}
+}
+
+// 从JSON文件中加载配置，文件中没有的字段使用默认值，时间可以写成"1s"这样的字符串
+func LoadServerConfig(path string) (*ServerConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	c := DefaultServerConfig()
+	if err = unmarshalConfig(data, reflect.ValueOf(c).Elem()); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// 从环境变量中加载配置，变量名为前缀加上大写的json字段名，如 GOEPOLL_WORK_COUNT、GOEPOLL_SOCK_OPTIONS_NO_DELAY
+func (c *ServerConfig) LoadEnv(prefix string) error {
+	_, err := loadEnvConfig(reflect.ValueOf(c).Elem(), prefix)
+	return err
+}
+
+// 校验反应堆配置
+func (c *ReactorConfig) Validate() error {
+	switch c.DemultiplexerType {
+	case EpollType:
+	default:
+		return DemultiplexerTypeUnknown
+	}
+	if c.DemultiplexerSize <= 0 {
+		return DemultiplexerSizeError
+	}
+	if c.EventSize <= 0 {
+		return fmt.Errorf("%w : event_size must be greater than 0", InvalidConfig)
+	}
+	if c.WorkCount <= 0 {
+		return fmt.Errorf("%w : work_count must be greater than 0", InvalidConfig)
+	}
+	return nil
+}
+
+// 校验服务器配置
+func (c *ServerConfig) Validate() error {
+	if err := c.ReactorConfig.Validate(); err != nil {
+		return err
+	}
+	if c.ReadChunkSize <= 0 {
+		return fmt.Errorf("%w : read_chunk_size must be greater than 0", InvalidConfig)
+	}
+	if c.WriteChunkSize <= 0 {
+		return fmt.Errorf("%w : write_chunk_size must be greater than 0", InvalidConfig)
+	}
+	if c.BufferSize <= 0 {
+		return fmt.Errorf("%w : buffer_size must be greater than 0", InvalidConfig)
+	}
+	if c.MaxConns < 0 {
+		return fmt.Errorf("%w : max_conns must not be negative", InvalidConfig)
+	}
+	switch c.OverflowPolicy {
+	case OverflowReject, OverflowPause:
+	default:
+		return fmt.Errorf("%w : overflow_policy unknown", InvalidConfig)
+	}
+	if c.AcceptRate < 0 {
+		return fmt.Errorf("%w : accept_rate must not be negative", InvalidConfig)
+	}
+	if c.SockOptions == nil {
+		return fmt.Errorf("%w : sock_options must not be nil", InvalidConfig)
+	}
+	if _, err := ParsePrefixes(c.ProxyProtocolTrusted); err != nil {
+		return fmt.Errorf("%w : proxy_protocol_trusted %s", InvalidConfig, err.Error())
+	}
+	if c.IdleTimeout < 0 || c.ReadTimeout < 0 || c.WriteTimeout < 0 {
+		return fmt.Errorf("%w : timeout must not be negative", InvalidConfig)
+	}
+	if c.FlushTimeout <= 0 {
+		return fmt.Errorf("%w : flush_timeout must be greater than 0", InvalidConfig)
+	}
+	if c.TimeoutTick <= 0 {
+		return fmt.Errorf("%w : timeout_tick must be greater than 0", InvalidConfig)
+	}
+	if c.WriteHighWatermark < 0 || c.WriteLowWatermark < 0 || (c.WriteHighWatermark > 0 && c.WriteLowWatermark >= c.WriteHighWatermark) {
+		return fmt.Errorf("%w : write_low_watermark must be less than write_high_watermark", InvalidConfig)
+	}
+	switch c.WriteFullPolicy {
+	case WriteFullError, WriteFullBlock:
+	default:
+		return fmt.Errorf("%w : write_full_policy unknown", InvalidConfig)
+	}
+	if c.WriteFullTimeout < 0 {
+		return fmt.Errorf("%w : write_full_timeout must not be negative", InvalidConfig)
+	}
+	if c.ZeroCopyThreshold < 0 {
+		return fmt.Errorf("%w : zero_copy_threshold must not be negative", InvalidConfig)
+	}
+	if c.Heartbeat != nil {
+		if err := c.Heartbeat.Validate(); err != nil {
+			return err
+		}
+	}
+	if c.TLSConfig != nil && len(c.TLSConfig.Certificates) == 0 && c.TLSConfig.GetCertificate == nil && c.TLSConfig.GetConfigForClient == nil {
+		return fmt.Errorf("%w : tls config has no certificate", InvalidConfig)
+	}
+	return nil
+}
+
+// 设置多路复用器类型与数量
+func WithReactorDemultiplexer(t EventDemultiplexerType, size int) ReactorOption {
+	return func(c *ReactorConfig) {
+		c.DemultiplexerType = t
+		c.DemultiplexerSize = size
+	}
+}
+
+// 设置每个多路复用器一次最多返回的事件数量
+func WithReactorEventSize(size int) ReactorOption {
+	return func(c *ReactorConfig) {
+		c.EventSize = size
+	}
+}
+
+// 设置事件工作池协程数量
+func WithReactorWorkCount(count int) ReactorOption {
+	return func(c *ReactorConfig) {
+		c.WorkCount = count
+	}
+}
+
+// 使用完整的反应堆配置
+func WithReactorConfig(cfg ReactorConfig) ReactorOption {
+	return func(c *ReactorConfig) {
+		*c = cfg
+	}
+}
+
+// 使用完整的服务器配置，会覆盖之前的选项
+func WithConfig(cfg *ServerConfig) ServerOption {
+	return func(c *ServerConfig) {
+		*c = *cfg
+	}
+}
+
+// 设置多路复用器类型与数量
+func WithDemultiplexer(t EventDemultiplexerType, size int) ServerOption {
+	return func(c *ServerConfig) {
+		c.DemultiplexerType = t
+		c.DemultiplexerSize = size
+	}
+}
+
+// 设置每个多路复用器一次最多返回的事件数量
+func WithEventSize(size int) ServerOption {
+	return func(c *ServerConfig) {
+		c.EventSize = size
+	}
+}
+
+// 设置事件工作池协程数量
+func WithWorkCount(count int) ServerOption {
+	return func(c *ServerConfig) {
+		c.WorkCount = count
+	}
+}
+
+// 设置每次从fd中读取的字节数
+func WithReadChunkSize(size int) ServerOption {
+	return func(c *ServerConfig) {
+		c.ReadChunkSize = size
+	}
+}
+
+// 设置每次向fd中写入的字节数
+func WithWriteChunkSize(size int) ServerOption {
+	return func(c *ServerConfig) {
+		c.WriteChunkSize = size
+	}
+}
+
+// 设置缓冲池中缓冲的初始大小
+func WithBufferSize(size int) ServerOption {
+	return func(c *ServerConfig) {
+		c.BufferSize = size
+	}
+}
+
+// 设置最大连接数，以及超出上限时的处理策略
+func WithMaxConns(max int, policy OverflowPolicy) ServerOption {
+	return func(c *ServerConfig) {
+		c.MaxConns = max
+		c.OverflowPolicy = policy
+	}
+}
+
+// 设置每秒最多接收的连接数，以及允许的突发数量
+func WithAcceptRate(rate float64, burst int) ServerOption {
+	return func(c *ServerConfig) {
+		c.AcceptRate = rate
+		c.AcceptBurst = burst
+	}
+}
+
+// 设置准入控制
+func WithAdmission(admission *Admission) ServerOption {
+	return func(c *ServerConfig) {
+		c.Admission = admission
+	}
+}
+
+// 设置socket选项，没有设置的字段使用DefaultSockOptions中的值，为nil时创建服务器返回错误
+func WithSockOptions(opts *SockOptions) ServerOption {
+	return func(c *ServerConfig) {
+		if opts == nil {
+			c.SockOptions = nil
+			return
+		}
+		c.SockOptions = opts.withDefaults()
+	}
+}
+
+// 开启TLS，SNI证书选择与客户端证书认证通过tls.Config的GetCertificate与ClientAuth配置
+func WithTLSConfig(config *tls.Config) ServerOption {
+	return func(c *ServerConfig) {
+		c.TLSConfig = config
+	}
+}
+
+// 设置TLS握手超时时间
+func WithTLSHandshakeTimeout(timeout time.Duration) ServerOption {
+	return func(c *ServerConfig) {
+		c.TLSHandshakeTimeout = timeout
+	}
+}
+
+// 开启PROXY协议，trusted为可信的上游CIDR，为空表示信任所有
+func WithProxyProtocol(trusted ...string) ServerOption {
+	return func(c *ServerConfig) {
+		c.ProxyProtocol = true
+		c.ProxyProtocolTrusted = trusted
+	}
+}
+
+// 设置空闲超时时间
+func WithIdleTimeout(timeout time.Duration) ServerOption {
+	return func(c *ServerConfig) {
+		c.IdleTimeout = timeout
+	}
+}
+
+// 设置读超时时间
+func WithReadTimeout(timeout time.Duration) ServerOption {
+	return func(c *ServerConfig) {
+		c.ReadTimeout = timeout
+	}
+}
+
+// 设置写超时时间
+func WithWriteTimeout(timeout time.Duration) ServerOption {
+	return func(c *ServerConfig) {
+		c.WriteTimeout = timeout
+	}
+}
+
+// 设置CloseAfterFlush的默认超时时间
+func WithFlushTimeout(timeout time.Duration) ServerOption {
+	return func(c *ServerConfig) {
+		c.FlushTimeout = timeout
+	}
+}
+
+// 设置超时检查的精度
+func WithTimeoutTick(tick time.Duration) ServerOption {
+	return func(c *ServerConfig) {
+		c.TimeoutTick = tick
+	}
+}
+
+// 开启心跳
+func WithHeartbeat(hc *HeartbeatConfig) ServerOption {
+	return func(c *ServerConfig) {
+		c.Heartbeat = hc
+	}
+}
+
+// 设置写缓冲的高低水位，low为0时使用高水位的一半
+func WithWriteWatermark(high, low int) ServerOption {
+	return func(c *ServerConfig) {
+		c.WriteHighWatermark = high
+		c.WriteLowWatermark = low
+	}
+}
+
+// 设置超过高水位时Write的处理策略
+func WithWriteFullPolicy(policy WriteFullPolicy) ServerOption {
+	return func(c *ServerConfig) {
+		c.WriteFullPolicy = policy
+	}
+}
+
+// 设置超过高水位的最长时间，超过后关闭连接
+func WithWriteFullTimeout(timeout time.Duration) ServerOption {
+	return func(c *ServerConfig) {
+		c.WriteFullTimeout = timeout
+	}
+}
+
+// 设置使用MSG_ZEROCOPY发送的最小字节数，0表示不使用
+func WithZeroCopyThreshold(threshold int) ServerOption {
+	return func(c *ServerConfig) {
+		c.ZeroCopyThreshold = threshold
+	}
+}
+
+// 设置日志
+func WithLogger(lg *Logger) ServerOption {
+	return func(c *ServerConfig) {
+		c.Logger = lg
+	}
+}
+
+var (
+	durationType        = reflect.TypeOf(time.Duration(0))
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// 获取字段的json名称
+func configFieldName(f reflect.StructField) string {
+	name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+	return name
+}
+
+// 按照json标签解析配置，时间字段支持"1s"格式的字符串，也支持纳秒数字
+func unmarshalConfig(data []byte, v reflect.Value) error {
+	raw := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		fv := v.Field(i)
+		if f.Anonymous {
+			if err := unmarshalConfig(data, fv); err != nil {
+				return err
+			}
+			continue
+		}
+		name := configFieldName(f)
+		if name == "" || name == "-" {
+			continue
+		}
+		msg, ok := raw[name]
+		if !ok {
+			continue
+		}
+		switch {
+		case f.Type == durationType:
+			var s string
+			if json.Unmarshal(msg, &s) == nil {
+				d, err := time.ParseDuration(s)
+				if err != nil {
+					return fmt.Errorf("%w : %s %s", InvalidConfig, name, err.Error())
+				}
+				fv.SetInt(int64(d))
+				continue
+			}
+			var n int64
+			if err := json.Unmarshal(msg, &n); err != nil {
+				return fmt.Errorf("%w : %s %s", InvalidConfig, name, err.Error())
+			}
+			fv.SetInt(n)
+		case fv.Addr().Type().Implements(textUnmarshalerType) && len(msg) > 0 && msg[0] != '"':
+			//枚举类型同时支持字符串与数字
+			if err := fv.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText(msg); err != nil {
+				return err
+			}
+		case f.Type.Kind() == reflect.Pointer && f.Type.Elem().Kind() == reflect.Struct:
+			if fv.IsNil() {
+				fv.Set(reflect.New(f.Type.Elem()))
+			}
+			if err := unmarshalConfig(msg, fv.Elem()); err != nil {
+				return err
+			}
+		default:
+			if err := json.Unmarshal(msg, fv.Addr().Interface()); err != nil {
+				return fmt.Errorf("%w : %s %s", InvalidConfig, name, err.Error())
+			}
+		}
+	}
+	return nil
+}
+
+// 按照json标签从环境变量中加载配置，返回是否找到了环境变量
+func loadEnvConfig(v reflect.Value, prefix string) (bool, error) {
+	found := false
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		fv := v.Field(i)
+		if f.Anonymous {
+			ok, err := loadEnvConfig(fv, prefix)
+			if err != nil {
+				return found, err
+			}
+			found = found || ok
+			continue
+		}
+		name := configFieldName(f)
+		if name == "" || name == "-" {
+			continue
+		}
+		key := strings.ToUpper(name)
+		if prefix != "" {
+			key = prefix + "_" + key
+		}
+		if f.Type.Kind() == reflect.Pointer && f.Type.Elem().Kind() == reflect.Struct {
+			//为nil的结构体，只有找到了环境变量才设置
+			elem := fv
+			if fv.IsNil() {
+				elem = reflect.New(f.Type.Elem())
+			}
+			ok, err := loadEnvConfig(elem.Elem(), key)
+			if err != nil {
+				return found, err
+			}
+			if ok && fv.IsNil() {
+				fv.Set(elem)
+			}
+			found = found || ok
+			continue
+		}
+		val, ok := os.LookupEnv(key)
+		if !ok {
+			continue
+		}
+		found = true
+		if err := setConfigField(fv, val); err != nil {
+			return found, fmt.Errorf("%w : %s %s", InvalidConfig, key, err.Error())
+		}
+	}
+	return found, nil
+}
+
+// 将字符串设置到配置字段中
+func setConfigField(fv reflect.Value, val string) error {
+	//可以不设置的字段，如SockOptions.ReuseAddr
+	if fv.Kind() == reflect.Pointer {
+		elem := reflect.New(fv.Type().Elem())
+		if err := setConfigField(elem.Elem(), val); err != nil {
+			return err
+		}
+		fv.Set(elem)
+		return nil
+	}
+	if fv.Addr().Type().Implements(textUnmarshalerType) {
+		return fv.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(val))
+	}
+	if fv.Type() == durationType {
+		d, err := time.ParseDuration(val)
+		if err != nil {
+			return err
+		}
+		fv.SetInt(int64(d))
+		return nil
+	}
+	switch fv.Kind() {
+	case reflect.Bool:
+		b, err := strconv.ParseBool(val)
+		if err != nil {
+			return err
+		}
+		fv.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(val, 10, 64)
+		if err != nil {
+			return err
+		}
+		fv.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(val, 10, 64)
+		if err != nil {
+			return err
+		}
+		fv.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		n, err := strconv.ParseFloat(val, 64)
+		if err != nil {
+			return err
+		}
+		fv.SetFloat(n)
+	case reflect.String:
+		fv.SetString(val)
+	case reflect.Slice:
+		//字节切片直接使用字符串
+		if fv.Type().Elem().Kind() == reflect.Uint8 {
+			fv.SetBytes([]byte(val))
+			return nil
+		}
+		//字符串切片使用逗号分隔
+		if fv.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("unsupported type %s", fv.Type())
+		}
+		items := strings.Split(val, ",")
+		for i := range items {
+			items[i] = strings.TrimSpace(items[i])
+		}
+		fv.Set(reflect.ValueOf(items).Convert(fv.Type()))
+	default:
+		return fmt.Errorf("unsupported type %s", fv.Type())
+	}
+	return nil
+}
@@ -0,0 +1,261 @@
+package go_epoll
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// 写入临时的配置文件
+func writeConfigFile(t *testing.T, data string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// 测试用例中JSON与环境变量表示的同一份配置
+const testConfigJSON = `{
+	"demultiplexer_type": "epoll",
+	"work_count": 8,
+	"read_chunk_size": 4096,
+	"max_conns": 100,
+	"overflow_policy": "pause",
+	"accept_rate": 50.5,
+	"sock_options": {"no_delay": true, "keep_alive_idle": "1m", "reuse_port": false},
+	"proxy_protocol": true,
+	"proxy_protocol_trusted": ["10.0.0.0/8", "192.168.0.0/16"],
+	"idle_timeout": "30s",
+	"read_timeout": 5000000000,
+	"heartbeat": {"interval": "10s", "max_missed": 3, "ping": "50494e47", "pong": "504f4e47"},
+	"write_full_policy": "block"
+}`
+
+var testConfigEnv = map[string]string{
+	"T_DEMULTIPLEXER_TYPE":           "epoll",
+	"T_WORK_COUNT":                   "8",
+	"T_READ_CHUNK_SIZE":              "4096",
+	"T_MAX_CONNS":                    "100",
+	"T_OVERFLOW_POLICY":              "pause",
+	"T_ACCEPT_RATE":                  "50.5",
+	"T_SOCK_OPTIONS_NO_DELAY":        "true",
+	"T_SOCK_OPTIONS_KEEP_ALIVE_IDLE": "1m",
+	"T_SOCK_OPTIONS_REUSE_PORT":      "false",
+	"T_PROXY_PROTOCOL":               "true",
+	"T_PROXY_PROTOCOL_TRUSTED":       "10.0.0.0/8, 192.168.0.0/16",
+	"T_IDLE_TIMEOUT":                 "30s",
+	"T_READ_TIMEOUT":                 "5s",
+	"T_HEARTBEAT_INTERVAL":           "10s",
+	"T_HEARTBEAT_MAX_MISSED":         "3",
+	"T_HEARTBEAT_PING":               "50494e47",
+	"T_HEARTBEAT_PONG":               "504f4e47",
+	"T_WRITE_FULL_POLICY":            "block",
+}
+
+// 检查测试配置中的字段，没有出现的字段保持默认值
+func checkTestConfig(t *testing.T, c *ServerConfig) {
+	t.Helper()
+	def := DefaultServerConfig()
+	if c.DemultiplexerType != EpollType || c.WorkCount != 8 || c.DemultiplexerSize != def.DemultiplexerSize {
+		t.Errorf("reactor config = %+v", c.ReactorConfig)
+	}
+	if c.ReadChunkSize != 4096 || c.WriteChunkSize != def.WriteChunkSize {
+		t.Errorf("chunk size = %d %d", c.ReadChunkSize, c.WriteChunkSize)
+	}
+	if c.MaxConns != 100 || c.OverflowPolicy != OverflowPause || c.AcceptRate != 50.5 {
+		t.Errorf("accept config = %d %v %v", c.MaxConns, c.OverflowPolicy, c.AcceptRate)
+	}
+	if !c.SockOptions.NoDelay || c.SockOptions.KeepAliveIdle != time.Minute || !*c.SockOptions.ReuseAddr || *c.SockOptions.ReusePort || c.SockOptions.Backlog != 1024 {
+		t.Errorf("sock options = %+v", c.SockOptions)
+	}
+	if !c.ProxyProtocol || !reflect.DeepEqual(c.ProxyProtocolTrusted, []string{"10.0.0.0/8", "192.168.0.0/16"}) {
+		t.Errorf("proxy protocol = %v %v", c.ProxyProtocol, c.ProxyProtocolTrusted)
+	}
+	if c.IdleTimeout != 30*time.Second || c.ReadTimeout != 5*time.Second || c.TimeoutTick != def.TimeoutTick {
+		t.Errorf("timeouts = %v %v %v", c.IdleTimeout, c.ReadTimeout, c.TimeoutTick)
+	}
+	hc := c.Heartbeat
+	if hc == nil || hc.Interval != 10*time.Second || hc.MaxMissed != 3 || string(hc.Ping) != "PING" || string(hc.Pong) != "PONG" {
+		t.Errorf("heartbeat = %+v", hc)
+	}
+	if c.WriteFullPolicy != WriteFullBlock {
+		t.Errorf("write full policy = %v", c.WriteFullPolicy)
+	}
+	if err := c.Validate(); err != nil {
+		t.Errorf("Validate() = %v", err)
+	}
+}
+
+func TestLoadServerConfig(t *testing.T) {
+	c, err := LoadServerConfig(writeConfigFile(t, testConfigJSON))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTestConfig(t, c)
+}
+
+func TestServerConfigLoadEnv(t *testing.T) {
+	for k, v := range testConfigEnv {
+		t.Setenv(k, v)
+	}
+	c := DefaultServerConfig()
+	if err := c.LoadEnv("T"); err != nil {
+		t.Fatal(err)
+	}
+	checkTestConfig(t, c)
+}
+
+// 同样的值从JSON与环境变量中加载得到相同的配置
+func TestConfigLoadersAgree(t *testing.T) {
+	fromJSON, err := LoadServerConfig(writeConfigFile(t, testConfigJSON))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range testConfigEnv {
+		t.Setenv(k, v)
+	}
+	fromEnv := DefaultServerConfig()
+	if err = fromEnv.LoadEnv("T"); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(fromJSON, fromEnv) {
+		t.Fatalf("json = %+v\nenv = %+v", fromJSON, fromEnv)
+	}
+}
+
+// 枚举既可以是字符串也可以是数字，时间既可以是字符串也可以是纳秒数
+func TestLoadServerConfigNumericValues(t *testing.T) {
+	c, err := LoadServerConfig(writeConfigFile(t, `{"overflow_policy": 2, "write_full_policy": 2, "idle_timeout": 1000}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.OverflowPolicy != OverflowPause || c.WriteFullPolicy != WriteFullBlock || c.IdleTimeout != time.Microsecond {
+		t.Fatalf("config = %v %v %v", c.OverflowPolicy, c.WriteFullPolicy, c.IdleTimeout)
+	}
+}
+
+// 没有找到环境变量时，为nil的结构体保持nil
+func TestServerConfigLoadEnvKeepsNilStruct(t *testing.T) {
+	t.Setenv("T_WORK_COUNT", "2")
+	c := DefaultServerConfig()
+	if err := c.LoadEnv("T"); err != nil {
+		t.Fatal(err)
+	}
+	if c.Heartbeat != nil {
+		t.Fatalf("heartbeat = %+v, want nil", c.Heartbeat)
+	}
+	if c.WorkCount != 2 {
+		t.Fatalf("work count = %d", c.WorkCount)
+	}
+}
+
+func TestLoadServerConfigErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+	}{
+		{"bad json", `{"work_count": `},
+		{"bad duration", `{"idle_timeout": "30x"}`},
+		{"bad duration type", `{"idle_timeout": true}`},
+		{"bad policy", `{"overflow_policy": "drop"}`},
+		{"bad policy number", `{"overflow_policy": "3"}`},
+		{"bad write full policy", `{"write_full_policy": "drop"}`},
+		{"bad write full policy number", `{"write_full_policy": "3"}`},
+		{"bad int", `{"max_conns": "many"}`},
+		{"bad nested field", `{"sock_options": {"keep_alive_idle": "soon"}}`},
+		{"bad heartbeat frame", `{"heartbeat": {"ping": "PING"}}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := LoadServerConfig(writeConfigFile(t, tt.data)); err == nil {
+				t.Fatal("expected error")
+			}
+		})
+	}
+
+	if _, err := LoadServerConfig(filepath.Join(t.TempDir(), "missing.json")); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("missing file err = %v", err)
+	}
+}
+
+func TestServerConfigLoadEnvErrors(t *testing.T) {
+	tests := []struct {
+		key string
+		val string
+	}{
+		{"T_IDLE_TIMEOUT", "30x"},
+		{"T_WORK_COUNT", "eight"},
+		{"T_PROXY_PROTOCOL", "maybe"},
+		{"T_ACCEPT_RATE", "fast"},
+		{"T_OVERFLOW_POLICY", "drop"},
+		{"T_OVERFLOW_POLICY", "0"},
+		{"T_OVERFLOW_POLICY", "257"},
+		{"T_WRITE_FULL_POLICY", "drop"},
+		{"T_WRITE_FULL_POLICY", "0"},
+		{"T_WRITE_FULL_POLICY", "258"},
+		{"T_SOCK_OPTIONS_BACKLOG", "big"},
+		{"T_HEARTBEAT_PING", "PING"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			t.Setenv(tt.key, tt.val)
+			err := DefaultServerConfig().LoadEnv("T")
+			if !errors.Is(err, InvalidConfig) {
+				t.Fatalf("err = %v, want InvalidConfig", err)
+			}
+		})
+	}
+}
+
+// 没有设置的socket选项使用默认值，设置为false的选项保持不变
+func TestSockOptionsDefaults(t *testing.T) {
+	tests := []struct {
+		name      string
+		opts      *SockOptions
+		reuseAddr bool
+		reusePort bool
+		backlog   int
+	}{
+		{"empty", &SockOptions{}, true, true, 1024},
+		{"partial", &SockOptions{NoDelay: true, Backlog: 16}, true, true, 16},
+		{"disable reuse port", &SockOptions{ReusePort: Bool(false)}, true, false, 1024},
+		{"disable both", &SockOptions{ReuseAddr: Bool(false), ReusePort: Bool(false)}, false, false, 1024},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := NewTcpServer("127.0.0.1:0", WithSockOptions(tt.opts))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer s.Close()
+			check := func(o *SockOptions) {
+				t.Helper()
+				if *o.ReuseAddr != tt.reuseAddr || *o.ReusePort != tt.reusePort || o.Backlog != tt.backlog {
+					t.Errorf("options = %v %v %d", *o.ReuseAddr, *o.ReusePort, o.Backlog)
+				}
+			}
+			check(s.GetSockOptions())
+			if err = s.SetSockOptions(tt.opts); err != nil {
+				t.Fatal(err)
+			}
+			check(s.GetSockOptions())
+		})
+	}
+
+	s, err := NewTcpServer("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if err = s.SetSockOptions(nil); !errors.Is(err, InvalidConfig) {
+		t.Fatalf("SetSockOptions(nil) = %v", err)
+	}
+	if _, err = NewTcpServer("127.0.0.1:0", WithSockOptions(nil)); !errors.Is(err, InvalidConfig) {
+		t.Fatalf("WithSockOptions(nil) = %v", err)
+	}
+}
@@ -0,0 +1,569 @@
+package go_epoll
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// 连接池配置
+type ConnPoolConfig struct {
+	Network             string             //网络类型，tcp、tcp4、tcp6、unix
+	MinConns            int                //每个地址最少保持的连接数
+	MaxConns            int                //每个地址最多的连接数
+	IdleTimeout         time.Duration      //超过MinConns的连接，空闲超过该时间就关闭，0表示不关闭
+	DialTimeout         time.Duration      //连接超时时间
+	HealthCheckInterval time.Duration      //检查间隔，包括健康检查、空闲连接回收与补充连接
+	HealthCheck         func(c *Conn) bool //健康检查，只检查空闲的连接，返回false时关闭连接，为nil时不检查
+	BackoffMin          time.Duration      //连接失败后重连的最小间隔
+	BackoffMax          time.Duration      //连接失败后重连的最大间隔
+}
+
+type ConnPoolOption func(*ConnPoolConfig)
+
+// 默认连接池配置
+func DefaultConnPoolConfig() ConnPoolConfig {
+	return ConnPoolConfig{
+		Network:             "tcp",
+		MinConns:            0,
+		MaxConns:            8,
+		IdleTimeout:         time.Minute,
+		DialTimeout:         3 * time.Second,
+		HealthCheckInterval: time.Second,
+		BackoffMin:          100 * time.Millisecond,
+		BackoffMax:          30 * time.Second,
+	}
+}
+
+// 校验连接池配置
+func (c *ConnPoolConfig) Validate() error {
+	if c.MaxConns <= 0 || c.MinConns < 0 || c.MinConns > c.MaxConns {
+		return ConnPoolConfigInvalid
+	}
+	if c.HealthCheckInterval <= 0 || c.BackoffMin <= 0 || c.BackoffMax < c.BackoffMin {
+		return ConnPoolConfigInvalid
+	}
+	return nil
+}
+
+// 设置网络类型
+func WithPoolNetwork(network string) ConnPoolOption {
+	return func(c *ConnPoolConfig) {
+		c.Network = network
+	}
+}
+
+// 设置每个地址的最少与最多连接数
+func WithPoolSize(min, max int) ConnPoolOption {
+	return func(c *ConnPoolConfig) {
+		c.MinConns = min
+		c.MaxConns = max
+	}
+}
+
+// 设置空闲连接的回收时间
+func WithPoolIdleTimeout(timeout time.Duration) ConnPoolOption {
+	return func(c *ConnPoolConfig) {
+		c.IdleTimeout = timeout
+	}
+}
+
+// 设置连接超时时间
+func WithPoolDialTimeout(timeout time.Duration) ConnPoolOption {
+	return func(c *ConnPoolConfig) {
+		c.DialTimeout = timeout
+	}
+}
+
+// 设置健康检查
+func WithPoolHealthCheck(interval time.Duration, check func(c *Conn) bool) ConnPoolOption {
+	return func(c *ConnPoolConfig) {
+		c.HealthCheckInterval = interval
+		c.HealthCheck = check
+	}
+}
+
+// 设置重连的退避间隔
+func WithPoolBackoff(min, max time.Duration) ConnPoolOption {
+	return func(c *ConnPoolConfig) {
+		c.BackoffMin = min
+		c.BackoffMax = max
+	}
+}
+
+// 客户端连接池，按地址分组，连接通过服务器的反应堆建立，与服务器接收的连接共用事件循环
+// 使用Get借出连接，用完后Put归还，也可以使用Send直接发送
+type ConnPool struct {
+	server  *TcpServer           //服务器指针
+	cfg     ConnPoolConfig       //配置
+	handler TcpServerHandler     //回调函数，为nil时使用服务器的
+	pools   map[string]*addrPool //按地址分组的连接
+	owners  sync.Map             //连接所属的地址分组
+	lock    sync.Mutex           //锁
+	closed  bool                 //是否已关闭
+	stop    chan struct{}        //关闭通道
+}
+
+// 单个地址的连接
+type addrPool struct {
+	pool     *ConnPool
+	addr     string
+	dialer   *Dialer
+	idle     []*Conn             //空闲的连接，最近归还的在最后
+	conns    map[*Conn]*poolConn //已连接的连接
+	dialing  int                 //正在连接的数量
+	failures int                 //连续失败的次数
+	nextDial time.Time           //下次允许连接的时间
+	notify   chan struct{}       //有连接可用或者连接数变化时关闭
+	lock     sync.Mutex          //锁
+}
+
+// 连接池中连接的状态
+type poolConn struct {
+	lastUsed time.Time //最后归还的时间
+	idle     bool      //是否空闲，在idle中或者正在检查
+}
+
+func NewConnPool(s *TcpServer, handler TcpServerHandler, opts ...ConnPoolOption) (*ConnPool, error) {
+	cfg := DefaultConnPoolConfig()
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	p := &ConnPool{
+		server:  s,
+		cfg:     cfg,
+		handler: handler,
+		pools:   make(map[string]*addrPool),
+		stop:    make(chan struct{}),
+	}
+
+	go p.run()
+
+	return p, nil
+}
+
+// 获取地址分组，不存在时创建
+func (p *ConnPool) getAddrPool(addr string) (*addrPool, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.closed {
+		return nil, ConnPoolClosed
+	}
+	if ap, ok := p.pools[addr]; ok {
+		return ap, nil
+	}
+
+	ap := &addrPool{
+		pool:   p,
+		addr:   addr,
+		conns:  make(map[*Conn]*poolConn),
+		notify: make(chan struct{}),
+	}
+	ap.dialer = NewDialer(p.server, p.cfg.DialTimeout)
+	ap.dialer.Handler = &poolHandler{ap: ap}
+	p.pools[addr] = ap
+	return ap, nil
+}
+
+// 添加地址，并预先建立MinConns个连接
+func (p *ConnPool) AddAddr(addr string) error {
+	ap, err := p.getAddrPool(addr)
+	if err != nil {
+		return err
+	}
+	ap.fill()
+	return nil
+}
+
+// 借出一个连接，没有空闲的连接时发起新连接，达到上限时等待其它连接归还，直到ctx结束
+func (p *ConnPool) Get(ctx context.Context, addr string) (*Conn, error) {
+	ap, err := p.getAddrPool(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		ap.lock.Lock()
+		//优先使用最近归还的连接
+		for len(ap.idle) > 0 {
+			c := ap.idle[len(ap.idle)-1]
+			ap.idle = ap.idle[:len(ap.idle)-1]
+			if pc, ok := ap.conns[c]; ok && !c.IsClosed() {
+				pc.idle = false
+				ap.lock.Unlock()
+				return c, nil
+			}
+		}
+		notify := ap.notify
+		dial := ap.reserveDial()
+		retry := time.Until(ap.nextDial)
+		ap.lock.Unlock()
+
+		if dial {
+			ap.dial()
+		}
+
+		//正在退避，到时间后再尝试连接
+		if err = ap.wait(ctx, notify, retry); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// 等待有连接可用，retry > 0 时最多等待retry
+func (ap *addrPool) wait(ctx context.Context, notify chan struct{}, retry time.Duration) error {
+	var timeout <-chan time.Time
+	if retry > 0 {
+		timer := time.NewTimer(retry)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-ap.pool.stop:
+		return ConnPoolClosed
+	case <-notify:
+	case <-timeout:
+	}
+	return nil
+}
+
+// 归还连接，已关闭的连接直接丢弃，已经空闲的连接重复归还时忽略
+func (p *ConnPool) Put(c *Conn) {
+	v, ok := p.owners.Load(c)
+	if !ok || c.IsClosed() {
+		return
+	}
+	ap := v.(*addrPool)
+
+	ap.lock.Lock()
+	defer ap.lock.Unlock()
+
+	pc, ok := ap.conns[c]
+	if !ok || pc.idle {
+		return
+	}
+	pc.idle = true
+	pc.lastUsed = time.Now()
+	ap.idle = append(ap.idle, c)
+	ap.wake()
+}
+
+// 借出连接发送数据后立即归还，适用于可以在同一连接上并发请求的协议
+func (p *ConnPool) Send(ctx context.Context, addr string, data []byte) error {
+	c, err := p.Get(ctx, addr)
+	if err != nil {
+		return err
+	}
+	defer p.Put(c)
+
+	_, err = c.Write(data)
+	return err
+}
+
+// 获取地址的连接数，包括已连接与正在连接的，以及其中空闲的数量
+func (p *ConnPool) Len(addr string) (total int, idle int) {
+	p.lock.Lock()
+	ap, ok := p.pools[addr]
+	p.lock.Unlock()
+	if !ok {
+		return 0, 0
+	}
+
+	ap.lock.Lock()
+	defer ap.lock.Unlock()
+	return len(ap.conns) + ap.dialing, len(ap.idle)
+}
+
+// 关闭连接池，并关闭所有连接
+func (p *ConnPool) Close() {
+	p.lock.Lock()
+	if p.closed {
+		p.lock.Unlock()
+		return
+	}
+	p.closed = true
+	close(p.stop)
+	pools := p.pools
+	p.lock.Unlock()
+
+	for _, ap := range pools {
+		ap.lock.Lock()
+		conns := make([]*Conn, 0, len(ap.conns))
+		for c := range ap.conns {
+			conns = append(conns, c)
+		}
+		ap.lock.Unlock()
+
+		for _, c := range conns {
+			c.Close()
+		}
+	}
+}
+
+// 是否已关闭
+func (p *ConnPool) isClosed() bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.closed
+}
+
+// 定时检查所有地址的连接
+func (p *ConnPool) run() {
+	ticker := time.NewTicker(p.cfg.HealthCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+			p.lock.Lock()
+			pools := make([]*addrPool, 0, len(p.pools))
+			for _, ap := range p.pools {
+				pools = append(pools, ap)
+			}
+			p.lock.Unlock()
+
+			for _, ap := range pools {
+				ap.check()
+				ap.fill()
+			}
+		}
+	}
+}
+
+// 是否可以发起新连接，可以时占用一个连接数，需要持有锁
+func (ap *addrPool) reserveDial() bool {
+	if len(ap.conns)+ap.dialing >= ap.pool.cfg.MaxConns {
+		return false
+	}
+	if time.Now().Before(ap.nextDial) {
+		return false
+	}
+	//连接失败后，同一时间只允许一个连接去重试
+	if ap.failures > 0 && ap.dialing > 0 {
+		return false
+	}
+	ap.dialing++
+	return true
+}
+
+// 发起连接，需要先调用reserveDial
+func (ap *addrPool) dial() {
+	if _, err := ap.dialer.Dial(ap.pool.cfg.Network, ap.addr); err != nil {
+		ap.pool.server.getLogger().Warnf(context.Background(), "pool dial conn[%s] error : %s", ap.addr, err.Error())
+		ap.lock.Lock()
+		ap.dialing--
+		ap.fail()
+		ap.lock.Unlock()
+	}
+}
+
+// 补充连接到MinConns
+func (ap *addrPool) fill() {
+	for {
+		ap.lock.Lock()
+		dial := len(ap.conns)+ap.dialing < ap.pool.cfg.MinConns && ap.reserveDial()
+		ap.lock.Unlock()
+		if !dial {
+			return
+		}
+		ap.dial()
+	}
+}
+
+// 检查空闲的连接，关闭不健康的以及空闲太久的
+func (ap *addrPool) check() {
+	cfg := ap.pool.cfg
+	now := time.Now()
+
+	ap.lock.Lock()
+	idle := ap.idle
+	ap.idle = make([]*Conn, 0, len(idle))
+	ap.lock.Unlock()
+
+	keep := make([]*Conn, 0, len(idle))
+	evict := make([]*Conn, 0)
+	for _, c := range idle {
+		if c.IsClosed() {
+			continue
+		}
+		if cfg.HealthCheck != nil && !cfg.HealthCheck(c) {
+			evict = append(evict, c)
+			continue
+		}
+		keep = append(keep, c)
+	}
+
+	ap.lock.Lock()
+	total := len(ap.conns)
+	checked := keep[:0]
+	for _, c := range keep {
+		//检查期间已经关闭
+		pc, ok := ap.conns[c]
+		if !ok {
+			continue
+		}
+		//超过MinConns的连接，空闲太久就关闭，最早归还的在前面
+		if cfg.IdleTimeout > 0 && total-len(evict) > cfg.MinConns && now.Sub(pc.lastUsed) >= cfg.IdleTimeout {
+			evict = append(evict, c)
+			continue
+		}
+		checked = append(checked, c)
+	}
+	//检查期间归还的连接放在最后
+	ap.idle = append(checked, ap.idle...)
+	ap.lock.Unlock()
+
+	for _, c := range evict {
+		c.Close()
+	}
+}
+
+// 连接失败，计算下次连接的时间，需要持有锁
+func (ap *addrPool) fail() {
+	ap.failures++
+	ap.nextDial = time.Now().Add(ap.backoff())
+	ap.wake()
+}
+
+// 指数退避，并加上随机抖动，避免大量连接同时重连
+func (ap *addrPool) backoff() time.Duration {
+	cfg := ap.pool.cfg
+	d := cfg.BackoffMin
+	for i := 1; i < ap.failures && d < cfg.BackoffMax; i++ {
+		d *= 2
+	}
+	if d > cfg.BackoffMax {
+		d = cfg.BackoffMax
+	}
+	//在[d/2, d]之间随机
+	half := int64(d / 2)
+	return time.Duration(half + rand.Int63n(half+1))
+}
+
+// 唤醒等待连接的协程，需要持有锁
+func (ap *addrPool) wake() {
+	close(ap.notify)
+	ap.notify = make(chan struct{})
+}
+
+// 连接池的回调函数，维护连接状态后再调用用户的回调
+type poolHandler struct {
+	ap *addrPool
+}
+
+func (h *poolHandler) handler() TcpServerHandler {
+	if h.ap.pool.handler != nil {
+		return h.ap.pool.handler
+	}
+	return h.ap.pool.server.handler
+}
+
+func (h *poolHandler) OnConnect(c *Conn) {
+	ap := h.ap
+	ap.pool.owners.Store(c, ap)
+
+	ap.lock.Lock()
+	ap.dialing--
+	ap.failures = 0
+	ap.nextDial = time.Time{}
+	ap.conns[c] = &poolConn{lastUsed: time.Now()}
+	ap.lock.Unlock()
+
+	h.handler().OnConnect(c)
+
+	//连接期间连接池已经关闭，Close时可能还没有加入conns
+	if ap.pool.isClosed() {
+		c.Close()
+		return
+	}
+	//在回调之后才可以借出，回调中可以设置连接，如心跳
+	ap.pool.Put(c)
+}
+
+func (h *poolHandler) OnData(c *Conn, data []byte) {
+	h.handler().OnData(c, data)
+}
+
+func (h *poolHandler) OnError(c *Conn) {
+	h.OnErrorErr(c, c.CloseErr())
+}
+
+func (h *poolHandler) OnErrorErr(c *Conn, err error) {
+	if eh, ok := h.handler().(TcpServerErrorErrHandler); ok {
+		eh.OnErrorErr(c, err)
+	} else {
+		h.handler().OnError(c)
+	}
+}
+
+// 连接成功之前关闭，包括连接失败、超时以及连接中被关闭，在OnErrorErr之前调用
+// 没有原因时是本端调用了Close，不算连接失败
+func (h *poolHandler) onDialClose(c *Conn, err error) {
+	ap := h.ap
+	ap.lock.Lock()
+	ap.dialing--
+	if err != nil {
+		ap.fail()
+	} else {
+		ap.wake()
+	}
+	ap.lock.Unlock()
+}
+
+func (h *poolHandler) OnWriteBufferFull(c *Conn) {
+	if wh, ok := h.handler().(TcpServerWritableHandler); ok {
+		wh.OnWriteBufferFull(c)
+	}
+}
+
+func (h *poolHandler) OnWritable(c *Conn) {
+	if wh, ok := h.handler().(TcpServerWritableHandler); ok {
+		wh.OnWritable(c)
+	}
+}
+
+// 对端关闭了写，回调函数没有实现OnHalfClose时关闭连接
+func (h *poolHandler) OnHalfClose(c *Conn) {
+	if hh, ok := h.handler().(TcpServerHalfCloseHandler); ok {
+		hh.OnHalfClose(c)
+		return
+	}
+	c.Close()
+}
+
+func (h *poolHandler) OnClose(c *Conn) {
+	h.OnCloseErr(c, nil)
+}
+
+func (h *poolHandler) OnCloseErr(c *Conn, err error) {
+	ap := h.ap
+	ap.pool.owners.Delete(c)
+
+	ap.lock.Lock()
+	delete(ap.conns, c)
+	for i, ic := range ap.idle {
+		if ic == c {
+			ap.idle = append(ap.idle[:i], ap.idle[i+1:]...)
+			break
+		}
+	}
+	ap.wake()
+	ap.lock.Unlock()
+
+	if eh, ok := h.handler().(TcpServerCloseErrHandler); ok {
+		eh.OnCloseErr(c, err)
+	} else {
+		h.handler().OnClose(c)
+	}
+}
@@ -0,0 +1,112 @@
+package go_epoll
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestConnPoolBackoff(t *testing.T) {
+	cfg := DefaultConnPoolConfig()
+	cfg.BackoffMin = 100 * time.Millisecond
+	cfg.BackoffMax = time.Second
+	tests := []struct {
+		failures int
+		max      time.Duration
+	}{
+		{1, 100 * time.Millisecond},
+		{2, 200 * time.Millisecond},
+		{3, 400 * time.Millisecond},
+		{4, 800 * time.Millisecond},
+		{5, time.Second},
+		{50, time.Second},
+	}
+	for _, tt := range tests {
+		ap := &addrPool{pool: &ConnPool{cfg: cfg}, failures: tt.failures}
+		for i := 0; i < 100; i++ {
+			//在[max/2, max]之间随机
+			if d := ap.backoff(); d < tt.max/2 || d > tt.max {
+				t.Fatalf("failures %d: backoff = %v, want in [%v, %v]", tt.failures, d, tt.max/2, tt.max)
+			}
+		}
+	}
+}
+
+// 借出与归还时的连接数，重复归还的连接不会被借给两个调用方
+func TestConnPoolGetPut(t *testing.T) {
+	s, addr := newTestServer(t, &testHandler{})
+	p, err := NewConnPool(s, &testHandler{}, WithPoolSize(0, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	c1, err := p.Get(ctx, addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total, idle := p.Len(addr); total != 1 || idle != 0 {
+		t.Fatalf("Len() after Get = %d %d", total, idle)
+	}
+	p.Put(c1)
+	p.Put(c1)
+	if total, idle := p.Len(addr); total != 1 || idle != 1 {
+		t.Fatalf("Len() after Put twice = %d %d", total, idle)
+	}
+
+	a, err := p.Get(ctx, addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := p.Get(ctx, addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a == b {
+		t.Fatal("same conn returned twice")
+	}
+	if total, idle := p.Len(addr); total != 2 || idle != 0 {
+		t.Fatalf("Len() = %d %d", total, idle)
+	}
+
+	//达到上限时等待归还
+	short, cancelShort := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancelShort()
+	if _, err = p.Get(short, addr); err != context.DeadlineExceeded {
+		t.Fatalf("Get over limit = %v", err)
+	}
+	p.Put(b)
+	if c, err := p.Get(ctx, addr); err != nil || c != b {
+		t.Fatalf("Get after Put = %v %v", c, err)
+	}
+}
+
+// 关闭时正在连接的连接，连接成功后也会被关闭
+func TestConnPoolCloseWhileDialing(t *testing.T) {
+	var closed int32
+	h := &testHandler{onClose: func(c *Conn, err error) {
+		atomic.AddInt32(&closed, 1)
+	}}
+	s, addr := newTestServer(t, h)
+	p, err := NewConnPool(s, &testHandler{}, WithPoolSize(4, 4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = p.AddAddr(addr); err != nil {
+		t.Fatal(err)
+	}
+	p.Close()
+
+	//连接池的连接关闭后，服务器接收的连接也会关闭
+	deadline := time.Now().Add(5 * time.Second)
+	for atomic.LoadInt32(&closed) < 4 {
+		if time.Now().After(deadline) {
+			t.Fatalf("closed = %d, want 4", atomic.LoadInt32(&closed))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,200 @@
+package go_epoll
+
+import (
+	"context"
+	"golang.org/x/sys/unix"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// 拨号器，使用服务器的反应堆发起非阻塞连接，与服务器接收的连接共用事件循环
+// 连接成功后调用OnConnect，连接失败或超时调用OnError或者OnErrorErr，可以通过CloseErr获取原因，如unix.ECONNREFUSED、ConnDialTimeout
+// 服务器不需要调用Serve，第一次Dial时会启动事件循环，使用完后调用服务器的Close释放
+type Dialer struct {
+	server      *TcpServer       //服务器指针
+	Timeout     time.Duration    //连接超时时间，0表示不限制
+	Handler     TcpServerHandler //回调函数，为nil时使用服务器的
+	EnDecoder   EnDecoder        //编码解码，为nil时使用服务器的
+	SockOptions *SockOptions     //socket选项，为nil时使用服务器的
+}
+
+// 内部接口，发起的连接在调用连接回调之前关闭时调用，如连接失败、超时、连接中调用了Close或者服务器关闭
+// 这时不会调用关闭回调，连接池用它维护正在连接的数量
+type dialCloseHandler interface {
+	onDialClose(conn *Conn, err error)
+}
+
+func NewDialer(s *TcpServer, timeout time.Duration) *Dialer {
+	return &Dialer{
+		server:  s,
+		Timeout: timeout,
+	}
+}
+
+// 发起连接，network支持tcp、tcp4、tcp6、unix，立即失败时返回错误，否则连接结果通过回调通知
+func (d *Dialer) Dial(network, address string) (*Conn, error) {
+	if d.server.closed() {
+		return nil, ServerClosed
+	}
+	//只用于发起连接的服务器没有调用Serve
+	d.server.startLoop()
+
+	sa, domain, err := getDialSockAddr(network, address)
+	if err != nil {
+		return nil, err
+	}
+
+	fd, err := unix.Socket(domain, unix.SOCK_STREAM|unix.SOCK_NONBLOCK|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	//unix socket不支持TCP选项
+	if domain != unix.AF_UNIX {
+		opts := d.SockOptions
+		if opts == nil {
+			opts = d.server.cfg.SockOptions
+		}
+		if err = opts.applyConn(fd); err != nil {
+			unix.Close(fd)
+			return nil, err
+		}
+	}
+
+	conn := newConn(fd, address, d.server)
+	conn.outbound = true
+	if d.Handler != nil {
+		conn.handler = d.Handler
+	}
+	if d.EnDecoder != nil {
+		conn.endecoder = d.EnDecoder
+	}
+
+	err = unix.Connect(fd, sa)
+	if err != nil && err != unix.EINPROGRESS {
+		conn.discard()
+		return nil, err
+	}
+
+	d.server.dialManage.AddConn(conn)
+
+	//连接已经完成，如本机的unix socket
+	if err == nil {
+		if err = conn.register(); err != nil {
+			d.server.dialManage.DelConn(conn)
+			conn.discard()
+			return nil, err
+		}
+		conn.scheduleTimeout()
+		conn.start()
+		return conn, nil
+	}
+
+	//连接中，等待可写事件
+	atomic.StoreInt32(&conn.dialing, 1)
+	if d.Timeout > 0 {
+		atomic.StoreInt64(&conn.deadline, time.Now().Add(d.Timeout).UnixNano())
+	}
+	err = d.server.reactor.AddHandler(Event{
+		Fd:        fd,
+		EventType: EventWrite | EventError | EventET | EventOneShot,
+	}, conn.connectHandle)
+	if err != nil {
+		d.server.dialManage.DelConn(conn)
+		conn.discard()
+		return nil, err
+	}
+
+	if d.Timeout > 0 {
+		d.server.wheel.Add(conn, d.Timeout)
+	}
+
+	return conn, nil
+}
+
+// 获取连接的地址与地址族
+func getDialSockAddr(network, address string) (unix.Sockaddr, int, error) {
+	switch network {
+	case "unix":
+		return &unix.SockaddrUnix{Name: address}, unix.AF_UNIX, nil
+	case "tcp", "tcp4", "tcp6":
+		//域名会阻塞解析
+		tcpAddr, err := net.ResolveTCPAddr(network, address)
+		if err != nil {
+			return nil, 0, err
+		}
+		return GetSockAddr(tcpAddr.AddrPort().String())
+	}
+	return nil, 0, net.UnknownNetworkError(network)
+}
+
+// 连接事件处理
+func (c *Conn) connectHandle(ev *Event) {
+	if !c.incRef() {
+		return
+	}
+	defer c.decRef()
+
+	c.eventLock.Lock()
+	defer c.eventLock.Unlock()
+
+	if !atomic.CompareAndSwapInt32(&c.dialing, 1, 0) {
+		//已经超时关闭
+		return
+	}
+
+	//获取连接结果
+	errno, err := unix.GetsockoptInt(c.fd, unix.SOL_SOCKET, unix.SO_ERROR)
+	if err == nil && errno != 0 {
+		err = unix.Errno(errno)
+	}
+	if err == nil && !ev.IsWrite() {
+		err = unix.ECONNRESET
+	}
+	if err != nil {
+		c.dialFail(err)
+		return
+	}
+
+	//连接成功后更新为socket对端地址，unix socket仍然使用路径
+	if sa, e := unix.Getpeername(c.fd); e == nil {
+		if addrPort := GetAddrPortBySockAddr(sa); addrPort.IsValid() {
+			c.addr = addrPort.String()
+			c.peerAddr = c.addr
+			c.ip = addrPort.Addr()
+		}
+	}
+
+	c.server.wheel.Remove(c)
+	atomic.StoreInt64(&c.deadline, 0)
+	c.touch()
+
+	c.scheduleTimeout()
+	c.start()
+
+	//连接成功，改为监听读事件
+	if err = c.server.reactor.ModHandler(Event{
+		Fd:        c.fd,
+		EventType: EventRead | EventError | EventET | EventOneShot,
+	}, c.eventHandle); err != nil {
+		c.logger().Error(context.Background(), "ModHandler read error : ", err.Error())
+		c.closeWithErr(err)
+		return
+	}
+
+	//发送连接期间写入的数据
+	c.wLock.Lock()
+	if !c.writeQueue.Empty() {
+		atomic.StoreInt64(&c.writeStart, time.Now().UnixNano())
+	}
+	c.wLock.Unlock()
+	c.flush()
+}
+
+// 连接失败，关闭连接并调用出错回调
+func (c *Conn) dialFail(err error) {
+	c.logger().Warnf(context.Background(), "dial conn[%s] error : %s", c.addr, err.Error())
+	c.closeWithErr(err)
+	c.eventHandleError(err)
+}
@@ -0,0 +1,36 @@
+package go_epoll
+
+import (
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+)
+
+// 立即失败的连接归还读缓冲到池中
+func TestDialErrorReleasesBuffer(t *testing.T) {
+	s, err := NewTcpServer("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	var allocs int32
+	newBuf := s.bufPool.New
+	s.bufPool.New = func() any {
+		atomic.AddInt32(&allocs, 1)
+		return newBuf()
+	}
+
+	d := NewDialer(s, 0)
+	path := filepath.Join(t.TempDir(), "missing.sock")
+	const n = 100
+	for i := 0; i < n; i++ {
+		//不存在的unix socket，connect直接失败
+		if _, err = d.Dial("unix", path); err == nil {
+			t.Fatal("dial to missing socket succeeded")
+		}
+	}
+	//缓冲池可能丢弃部分缓冲，没有归还时每次都要分配
+	if a := atomic.LoadInt32(&allocs); a > n/2 {
+		t.Fatalf("allocs = %d, buffers not returned", a)
+	}
+}
@@ -7,4 +7,35 @@ var (
 	DemultiplexerSizeError   = errors.New("demultiplexer size ge 1")
 	EventHandlerNotFound     = errors.New("handler not found")
 	DataNotEnough            = errors.New("data Not enough")
+	InvalidConfig            = errors.New("invalid config")
+	ConnLimitExceeded        = errors.New("conn limit exceeded")
+	ConnRejected             = errors.New("conn rejected")
+	IPDenied                 = errors.New("ip denied")
+	IPNotAllowed             = errors.New("ip not allowed")
+	IPConnLimitExceeded      = errors.New("ip conn limit exceeded")
+	IPRateLimitExceeded      = errors.New("ip rate limit exceeded")
+	TLSCertificateNotFound   = errors.New("tls certificate not found")
+	ProxyHeaderInvalid       = errors.New("proxy header invalid")
+	ConnIdleTimeout          = errors.New("conn idle timeout")
+	ConnReadTimeout          = errors.New("conn read timeout")
+	ConnWriteTimeout         = errors.New("conn write timeout")
+	ConnHeartbeatTimeout     = errors.New("heartbeat timeout")
+	HeartbeatConfigInvalid   = errors.New("heartbeat config invalid")
+	ConnDialTimeout          = errors.New("dial timeout")
+	ConnPoolConfigInvalid    = errors.New("conn pool config invalid")
+	ConnPoolClosed           = errors.New("conn pool closed")
+	AdoptNotSupported        = errors.New("adopt not supported")
+	WriteBufferFull          = errors.New("write buffer full")
+	ConnWriteFullTimeout     = errors.New("conn write buffer full timeout")
+	ConnClosed               = errors.New("conn closed")
+	ConnAborted              = errors.New("conn aborted")
+	ConnFlushTimeout         = errors.New("conn flush timeout")
+	ConnPeerClosed           = errors.New("conn closed by peer")
+	ConnSocketError          = errors.New("conn socket error")
+	ConnDecodeFailed         = errors.New("conn decode failed")
+	TLSFailed                = errors.New("tls failed")
+	ServerClosed             = errors.New("server closed")
+	ConnWriteClosed          = errors.New("conn write closed")
+	ConnInRelay              = errors.New("conn already in relay")
+	RelayNotSupported        = errors.New("relay not supported")
 )
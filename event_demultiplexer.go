@@ -1,5 +1,10 @@
 package go_epoll
 
+import (
+	"strconv"
+	"strings"
+)
+
 type EventDemultiplexerType uint32
 
 // 多路复用器类型
@@ -7,6 +12,28 @@ const (
 	EpollType EventDemultiplexerType = iota + 1
 )
 
+func (t EventDemultiplexerType) String() string {
+	switch t {
+	case EpollType:
+		return "epoll"
+	}
+	return ""
+}
+
+func (t *EventDemultiplexerType) UnmarshalText(text []byte) error {
+	switch strings.ToLower(string(text)) {
+	case "epoll":
+		*t = EpollType
+	default:
+		n, err := strconv.Atoi(string(text))
+		if err != nil {
+			return DemultiplexerTypeUnknown
+		}
+		*t = EventDemultiplexerType(n)
+	}
+	return nil
+}
+
 // 事件多路复用器
 type EventDemultiplexer interface {
 	//添加事件
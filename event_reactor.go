@@ -17,14 +17,18 @@ type Reactor struct {
 	stop              chan struct{}
 }
 
-func NewReactor(dType EventDemultiplexerType, dSize int, eventSize int, workCount int) (*Reactor, error) {
-	if dSize <= 0 {
-		return nil, DemultiplexerSizeError
+func NewReactor(opts ...ReactorOption) (*Reactor, error) {
+	cfg := DefaultReactorConfig()
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
 	}
 
 	demultiplexer := make(map[int]EventDemultiplexer)
-	for i := 0; i < dSize; i++ {
-		d, err := NewEventDemultiplexer(dType, eventSize)
+	for i := 0; i < cfg.DemultiplexerSize; i++ {
+		d, err := NewEventDemultiplexer(cfg.DemultiplexerType, cfg.EventSize)
 		if err != nil {
 			logger.Error(context.Background(), "NewEventDemultiplexer error : ", err.Error())
 			return nil, err
@@ -34,12 +38,12 @@ func NewReactor(dType EventDemultiplexerType, dSize int, eventSize int, workCoun
 
 	return &Reactor{
 		demultiplexer:     demultiplexer,
-		demultiplexerSize: dSize,
+		demultiplexerSize: cfg.DemultiplexerSize,
 		handlers:          make(map[int]map[int]EventHandler),
 		handlersLock:      sync.RWMutex{},
 		wg:                sync.WaitGroup{},
 		totalEventNums:    0,
-		eventWorkPool:     NewEventWorkPool(workCount),
+		eventWorkPool:     NewEventWorkPool(cfg.WorkCount),
 		stop:              make(chan struct{}),
 	}, nil
 }
@@ -105,6 +109,14 @@ func (r *Reactor) ModHandler(ev Event, handler EventHandler) error {
 	return r.demultiplexer[index].ModEvent(ev)
 }
 
+// 获取事件handler，没有时返回nil
+func (r *Reactor) getHandler(ev Event) EventHandler {
+	r.handlersLock.RLock()
+	defer r.handlersLock.RUnlock()
+
+	return r.handlers[r.GetIndex(ev)][ev.Fd]
+}
+
 // 运行，等待事件发，并调用handler
 func (r *Reactor) Run() {
 	go r.eventWorkPool.Run()
@@ -127,8 +139,13 @@ func (r *Reactor) Run() {
 						return
 					}
 					for _, ev := range events {
+						//连接关闭时handler可能已经删除
+						handler := r.getHandler(*ev)
+						if handler == nil {
+							continue
+						}
 						//把事件压入工作池中执行
-						r.eventWorkPool.PushTaskFunc(r.handlers[r.GetIndex(*ev)][ev.Fd], ev)
+						r.eventWorkPool.PushTaskFunc(handler, ev)
 					}
 				}
 			}
@@ -144,7 +161,7 @@ func (r *Reactor) Close() {
 		d.Close()
 	}
 
-	r.stop <- struct{}{}
+	//等待事件的协程可能阻塞在Wait中，这里不能阻塞发送
 	close(r.stop)
 
 	r.eventWorkPool.Close()
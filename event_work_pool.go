@@ -67,20 +67,24 @@ func (wp *EventWorkPool) Run() {
 	wp.wg.Wait()
 }
 
+// 关闭后工作协程退出，不关闭taskQueue，正在压入任务的协程可能还在发送
+// 没有调用Run时也不会阻塞
 func (wp *EventWorkPool) Close() {
-	wp.stop <- struct{}{}
 	close(wp.stop)
-	close(wp.taskQueue)
 }
 
 func (wp *EventWorkPool) OnPanic(fn func(msg interface{})) {
 	wp.onPanic = fn
 }
 
+// 关闭后丢弃任务
 func (wp *EventWorkPool) PushTask(t *EventTask) {
-	wp.taskQueue <- t
+	select {
+	case wp.taskQueue <- t:
+	case <-wp.stop:
+	}
 }
 
 func (wp *EventWorkPool) PushTaskFunc(fn EventHandler, ev *Event) {
-	wp.taskQueue <- NewTask(fn, ev)
+	wp.PushTask(NewTask(fn, ev))
 }
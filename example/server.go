@@ -84,9 +84,15 @@ func (h *Handler) OnClose(conn *go_epoll.Conn) {
 }
 
 func main() {
-	go_epoll.SetLimit()
+	if err := go_epoll.SetLimit(); err != nil {
+		log.Println(err)
+	}
 
-	server, err := go_epoll.NewTcpServer("127.0.0.1:8080", go_epoll.EpollType, 10, 256, 20)
+	server, err := go_epoll.NewTcpServer("127.0.0.1:8080",
+		go_epoll.WithDemultiplexer(go_epoll.EpollType, 10),
+		go_epoll.WithEventSize(256),
+		go_epoll.WithWorkCount(20),
+	)
 	if err != nil {
 		log.Fatalln(err)
 	}
@@ -0,0 +1,207 @@
+package go_epoll
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 心跳配置，ping与pong为解码后的帧内容，发送时会经过编码
+type HeartbeatConfig struct {
+	Interval  time.Duration  `json:"interval"`   //心跳间隔，超过该时间没有收到数据就发送ping
+	MaxMissed int            `json:"max_missed"` //最多允许连续丢失的心跳次数，超过后关闭连接
+	Ping      HeartbeatFrame `json:"ping"`       //ping帧
+	Pong      HeartbeatFrame `json:"pong"`       //pong帧
+}
+
+// 心跳帧，配置文件与环境变量中都使用十六进制字符串，如"50494e47"表示"PING"
+type HeartbeatFrame []byte
+
+func (f HeartbeatFrame) MarshalText() ([]byte, error) {
+	return []byte(hex.EncodeToString(f)), nil
+}
+
+func (f *HeartbeatFrame) UnmarshalText(text []byte) error {
+	b, err := hex.DecodeString(string(text))
+	if err != nil {
+		return err
+	}
+	*f = b
+	return nil
+}
+
+// 校验心跳配置
+func (hc *HeartbeatConfig) Validate() error {
+	if hc.Interval <= 0 {
+		return HeartbeatConfigInvalid
+	}
+	if hc.MaxMissed < 0 {
+		return HeartbeatConfigInvalid
+	}
+	if len(hc.Ping) == 0 || len(hc.Pong) == 0 || bytes.Equal(hc.Ping, hc.Pong) {
+		return HeartbeatConfigInvalid
+	}
+	return nil
+}
+
+// 设置心跳，覆盖服务器的心跳配置，nil表示关闭心跳
+func (c *Conn) SetHeartbeat(hc *HeartbeatConfig) error {
+	if hc != nil {
+		if err := hc.Validate(); err != nil {
+			return err
+		}
+	}
+	c.heartbeat.Store(hc)
+	atomic.StoreInt64(&c.lastBeat, time.Now().UnixNano())
+	atomic.StoreInt32(&c.missedBeats, 0)
+	c.scheduleTimeout()
+	return nil
+}
+
+// 获取连续丢失的心跳次数
+func (c *Conn) MissedHeartbeats() int {
+	return int(atomic.LoadInt32(&c.missedBeats))
+}
+
+// 处理收到的帧，收到任何数据都说明对端存活，ping回复pong，返回true表示是心跳帧，不需要交给OnData
+func (c *Conn) handleHeartbeat(frame []byte) bool {
+	hc := c.heartbeat.Load()
+	if hc == nil {
+		return false
+	}
+	atomic.StoreInt64(&c.lastBeat, time.Now().UnixNano())
+	atomic.StoreInt32(&c.missedBeats, 0)
+
+	if bytes.Equal(frame, hc.Ping) {
+		c.Write(hc.Pong)
+		return true
+	}
+	return bytes.Equal(frame, hc.Pong)
+}
+
+// 检查心跳，超过间隔没有收到数据就发送ping，连续丢失超过上限返回错误
+// 在时间轮协程中调用，ping放入写缓冲后由可写事件发送，不会阻塞，还没有调用连接回调时不发送
+func (c *Conn) checkHeartbeat(now int64) error {
+	hc := c.heartbeat.Load()
+	if hc == nil {
+		return nil
+	}
+	missed := atomic.LoadInt32(&c.missedBeats)
+	if now-atomic.LoadInt64(&c.lastBeat) < int64(missed+1)*int64(hc.Interval) {
+		return nil
+	}
+	missed = atomic.AddInt32(&c.missedBeats, 1)
+	if int(missed) > hc.MaxMissed {
+		return ConnHeartbeatTimeout
+	}
+	//TLS握手还没有完成时写入会等待握手
+	if atomic.LoadInt32(&c.connected) == 0 {
+		return nil
+	}
+	//写缓冲满时丢弃这次ping，按丢失处理
+	c.AsyncWrite(hc.Ping, nil)
+	return nil
+}
+
+// 距离下次检查心跳的间隔，0表示没有开启心跳
+func (c *Conn) nextHeartbeatCheck(now int64) int64 {
+	hc := c.heartbeat.Load()
+	if hc == nil {
+		return 0
+	}
+	missed := atomic.LoadInt32(&c.missedBeats)
+	return atomic.LoadInt64(&c.lastBeat) + int64(missed+1)*int64(hc.Interval) - now
+}
+
+// 客户端心跳，与服务端的心跳配合使用，用于net.Conn
+// 超过间隔没有收到数据就发送ping，连续丢失超过上限就关闭连接
+type HeartbeatClient struct {
+	conn     net.Conn         //连接
+	cfg      *HeartbeatConfig //心跳配置
+	encoder  Encoder          //编码，为nil时直接发送
+	lastBeat int64            //最后收到数据的时间
+	missed   int32            //连续丢失的心跳次数
+	stop     chan struct{}    //关闭通道
+	once     sync.Once        //保证只关闭一次
+}
+
+func NewHeartbeatClient(conn net.Conn, cfg *HeartbeatConfig, encoder Encoder) (*HeartbeatClient, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return &HeartbeatClient{
+		conn:     conn,
+		cfg:      cfg,
+		encoder:  encoder,
+		lastBeat: time.Now().UnixNano(),
+		stop:     make(chan struct{}),
+	}, nil
+}
+
+// 开始心跳
+func (h *HeartbeatClient) Start() {
+	go func() {
+		ticker := time.NewTicker(h.cfg.Interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-h.stop:
+				return
+			case <-ticker.C:
+				missed := atomic.LoadInt32(&h.missed)
+				if time.Now().UnixNano()-atomic.LoadInt64(&h.lastBeat) < int64(missed+1)*int64(h.cfg.Interval) {
+					continue
+				}
+				if int(atomic.AddInt32(&h.missed, 1)) > h.cfg.MaxMissed {
+					logger.Warnf(context.Background(), "conn[%s] %s", h.conn.RemoteAddr(), ConnHeartbeatTimeout.Error())
+					h.conn.Close()
+					h.Stop()
+					return
+				}
+				h.send(h.cfg.Ping)
+			}
+		}
+	}()
+}
+
+// 停止心跳
+func (h *HeartbeatClient) Stop() {
+	h.once.Do(func() {
+		close(h.stop)
+	})
+}
+
+// 处理收到的帧，收到任何数据都说明对端存活，ping回复pong，返回true表示是心跳帧，调用方不需要再处理
+func (h *HeartbeatClient) Handle(frame []byte) bool {
+	atomic.StoreInt64(&h.lastBeat, time.Now().UnixNano())
+	atomic.StoreInt32(&h.missed, 0)
+
+	if bytes.Equal(frame, h.cfg.Ping) {
+		h.send(h.cfg.Pong)
+		return true
+	}
+	return bytes.Equal(frame, h.cfg.Pong)
+}
+
+// 获取连续丢失的心跳次数
+func (h *HeartbeatClient) Missed() int {
+	return int(atomic.LoadInt32(&h.missed))
+}
+
+// 编码后发送
+func (h *HeartbeatClient) send(frame []byte) error {
+	if h.encoder != nil {
+		encode, err := h.encoder.Encode(frame)
+		if err != nil {
+			return err
+		}
+		frame = encode
+	}
+	_, err := h.conn.Write(frame)
+	return err
+}
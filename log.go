@@ -74,11 +74,12 @@ func (l *Logger) clone() *Logger {
 	return &cl
 }
 
-// 添加字段
+// 添加字段，复制已有的字段，不会修改原来的Logger，可以在多个协程中同时调用
 func (l *Logger) WithFields(f LogFields) *Logger {
 	nl := l.clone()
-	if nl.fields == nil {
-		nl.fields = make(LogFields)
+	nl.fields = make(LogFields, len(l.fields)+len(f))
+	for k, v := range l.fields {
+		nl.fields[k] = v
 	}
 	for k, v := range f {
 		nl.fields[k] = v
@@ -0,0 +1,55 @@
+package go_epoll
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// WithFields返回新的Logger，不修改原来的字段
+func TestLoggerWithFieldsCopies(t *testing.T) {
+	var out bytes.Buffer
+	base := NewLogger(&out, "", 0).WithFields(LogFields{"server": "s1"})
+	a := base.WithFields(LogFields{"conn_id": 1})
+	b := base.WithFields(LogFields{"conn_id": 2, "server": "s2"})
+
+	if len(base.fields) != 1 || base.fields["server"] != "s1" {
+		t.Fatalf("base fields = %v", base.fields)
+	}
+	if a.fields["conn_id"] != 1 || a.fields["server"] != "s1" {
+		t.Fatalf("a fields = %v", a.fields)
+	}
+	if b.fields["conn_id"] != 2 || b.fields["server"] != "s2" {
+		t.Fatalf("b fields = %v", b.fields)
+	}
+
+	base.Info(context.Background(), "hello")
+	if strings.Contains(out.String(), "conn_id") {
+		t.Fatalf("base output has conn_id : %s", out.String())
+	}
+}
+
+// 多个协程同时从同一个Logger添加字段
+func TestLoggerWithFieldsConcurrent(t *testing.T) {
+	base := NewLogger(&bytes.Buffer{}, "", log.LstdFlags).WithFields(LogFields{"server": "s1"})
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				if l := base.WithFields(LogFields{"conn_id": i}); l.fields["conn_id"] != i {
+					t.Errorf("conn_id = %v, want %d", l.fields["conn_id"], i)
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	if len(base.fields) != 1 {
+		t.Fatalf("base fields = %v", base.fields)
+	}
+}
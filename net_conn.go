@@ -0,0 +1,345 @@
+package go_epoll
+
+import (
+	"bytes"
+	"context"
+	"golang.org/x/sys/unix"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// NetConn中还没有读取的数据超过该值时暂停读取，数据留在内核中，读取到一半以下时恢复
+const netConnMaxBuffered = 256 << 10
+
+// 基于反应堆的net.Listener，Accept返回的net.Conn由Conn实现，可以直接用于net/http等基于io.Reader的库
+// 读写只在等待数据时阻塞调用方的协程，事件循环与工作池不受影响
+type Listener struct {
+	server *TcpServer    //服务器指针
+	conns  sync.Map      //Conn对应的NetConn
+	accept chan *NetConn //已经连接，等待Accept的连接
+	closed chan struct{} //关闭通道
+	once   sync.Once     //保证只关闭一次
+}
+
+// 监听地址，并在后台开始接收连接
+func Listen(addr string, opts ...ServerOption) (*Listener, error) {
+	s, err := NewTcpServer(addr, opts...)
+	if err != nil {
+		return nil, err
+	}
+	l := NewListener(s)
+	if err = s.Listen(); err != nil {
+		s.Close()
+		return nil, err
+	}
+	go s.Serve()
+	return l, nil
+}
+
+// 使用服务器创建Listener，会替换服务器的回调函数，需要在Serve之前调用
+// 通过Dialer发起的连接需要设置自己的回调函数
+func NewListener(s *TcpServer) *Listener {
+	l := &Listener{
+		server: s,
+		accept: make(chan *NetConn, s.cfg.SockOptions.backlog()),
+		closed: make(chan struct{}),
+	}
+	s.SetHandler(&listenerHandler{l: l})
+	return l
+}
+
+// 获取服务器
+func (l *Listener) Server() *TcpServer {
+	return l.server
+}
+
+// 等待并返回下一个连接
+func (l *Listener) Accept() (net.Conn, error) {
+	select {
+	case nc := <-l.accept:
+		return nc, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+// 停止接收新连接，已经接收的连接不受影响，调用Server().Close()关闭所有连接
+func (l *Listener) Close() error {
+	l.once.Do(func() {
+		close(l.closed)
+		l.server.stopAccept()
+
+		//关闭还没有被Accept的连接
+		for {
+			select {
+			case nc := <-l.accept:
+				nc.Close()
+			default:
+				return
+			}
+		}
+	})
+	return nil
+}
+
+// 获取监听地址
+func (l *Listener) Addr() net.Addr {
+	sa, err := unix.Getsockname(l.server.fd)
+	if err != nil {
+		return nil
+	}
+	return GetNetAddrBySockAddr(sa)
+}
+
+// Listener的回调函数，把连接与数据转交给NetConn
+type listenerHandler struct {
+	l *Listener
+}
+
+// 获取连接对应的NetConn，不存在时创建，数据可能比连接回调先到达
+func (h *listenerHandler) netConn(c *Conn) *NetConn {
+	v, _ := h.l.conns.LoadOrStore(c, newNetConn(c))
+	return v.(*NetConn)
+}
+
+func (h *listenerHandler) OnConnect(c *Conn) {
+	nc := h.netConn(c)
+
+	select {
+	case <-h.l.closed:
+		c.Close()
+	case h.l.accept <- nc:
+	default:
+		//等待Accept的连接太多
+		c.logger().Warnf(context.Background(), "reject conn[%s] : accept queue full", c.addr)
+		c.Close()
+	}
+}
+
+func (h *listenerHandler) OnData(c *Conn, data []byte) {
+	h.netConn(c).feed(data)
+}
+
+func (h *listenerHandler) OnError(c *Conn) {
+}
+
+// 对端关闭了写，Read读完剩余的数据后返回io.EOF，仍然可以写
+func (h *listenerHandler) OnHalfClose(c *Conn) {
+	h.netConn(c).closeRead()
+}
+
+func (h *listenerHandler) OnClose(c *Conn) {
+	if v, ok := h.l.conns.LoadAndDelete(c); ok {
+		v.(*NetConn).closeRead()
+	}
+}
+
+// 基于Conn的net.Conn，Read在没有数据时阻塞，Write在写缓冲发送完之前阻塞
+type NetConn struct {
+	c             *Conn         //连接
+	in            bytes.Buffer  //已经收到还没有读取的数据
+	eof           bool          //对端关闭了写或者连接已关闭
+	paused        bool          //未读取的数据太多，暂停了读取
+	closed        bool          //本端调用了Close
+	readDeadline  time.Time     //读截止时间
+	writeDeadline time.Time     //写截止时间
+	notify        chan struct{} //有数据到达、连接关闭或者截止时间变化时关闭
+	lock          sync.Mutex    //锁
+}
+
+func newNetConn(c *Conn) *NetConn {
+	return &NetConn{
+		c:      c,
+		notify: make(chan struct{}),
+	}
+}
+
+// 获取底层的连接
+func (nc *NetConn) Conn() *Conn {
+	return nc.c
+}
+
+// 读数据，没有数据时阻塞，直到有数据到达、连接关闭或者超过读截止时间
+func (nc *NetConn) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	for {
+		nc.lock.Lock()
+		if nc.closed {
+			nc.lock.Unlock()
+			return 0, net.ErrClosed
+		}
+		if nc.in.Len() > 0 {
+			n, _ := nc.in.Read(p)
+			if nc.paused && nc.in.Len() <= netConnMaxBuffered/2 {
+				nc.paused = false
+				nc.c.ResumeRead()
+			}
+			nc.lock.Unlock()
+			return n, nil
+		}
+		if nc.eof {
+			nc.lock.Unlock()
+			return 0, io.EOF
+		}
+		notify, deadline := nc.notify, nc.readDeadline
+		nc.lock.Unlock()
+
+		if err := waitDeadline(notify, nil, deadline); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// 写数据，阻塞直到写缓冲中的数据发送完、连接关闭或者超过写截止时间
+func (nc *NetConn) Write(p []byte) (int, error) {
+	nc.lock.Lock()
+	closed, deadline := nc.closed, nc.writeDeadline
+	nc.lock.Unlock()
+	if closed || nc.c.IsClosed() {
+		return 0, net.ErrClosed
+	}
+	if !deadline.IsZero() && !time.Now().Before(deadline) {
+		return 0, os.ErrDeadlineExceeded
+	}
+
+	n, err := nc.c.Write(p)
+	if err != nil {
+		return n, err
+	}
+
+	for {
+		drain := nc.c.waitDrain()
+		if drain == nil {
+			break
+		}
+		nc.lock.Lock()
+		notify, deadline := nc.notify, nc.writeDeadline
+		nc.lock.Unlock()
+
+		if err = waitDeadline(drain, notify, deadline); err != nil {
+			return n, err
+		}
+	}
+
+	if nc.c.IsClosed() {
+		return n, net.ErrClosed
+	}
+	return n, nil
+}
+
+// 关闭
+func (nc *NetConn) Close() error {
+	nc.lock.Lock()
+	if nc.closed {
+		nc.lock.Unlock()
+		return net.ErrClosed
+	}
+	nc.closed = true
+	nc.wake()
+	nc.lock.Unlock()
+
+	return nc.c.Close()
+}
+
+// 发送完后关闭写，对端读到EOF
+func (nc *NetConn) CloseWrite() error {
+	return nc.c.CloseWrite()
+}
+
+// 关闭读
+func (nc *NetConn) CloseRead() error {
+	return nc.c.CloseRead()
+}
+
+func (nc *NetConn) LocalAddr() net.Addr {
+	return nc.c.LocalAddr()
+}
+
+func (nc *NetConn) RemoteAddr() net.Addr {
+	return nc.c.RemoteAddr()
+}
+
+// 设置读写截止时间，零值表示不限制，已经阻塞的读写也会生效
+func (nc *NetConn) SetDeadline(t time.Time) error {
+	nc.lock.Lock()
+	defer nc.lock.Unlock()
+
+	nc.readDeadline = t
+	nc.writeDeadline = t
+	nc.wake()
+	return nil
+}
+
+func (nc *NetConn) SetReadDeadline(t time.Time) error {
+	nc.lock.Lock()
+	defer nc.lock.Unlock()
+
+	nc.readDeadline = t
+	nc.wake()
+	return nil
+}
+
+func (nc *NetConn) SetWriteDeadline(t time.Time) error {
+	nc.lock.Lock()
+	defer nc.lock.Unlock()
+
+	nc.writeDeadline = t
+	nc.wake()
+	return nil
+}
+
+// 写入收到的数据，数据在回调返回后会被复用，所以需要复制
+// 调用方一直不读取时，超过上限后暂停读取，不会无限增长
+func (nc *NetConn) feed(p []byte) {
+	nc.lock.Lock()
+	defer nc.lock.Unlock()
+
+	nc.in.Write(p)
+	if !nc.paused && nc.in.Len() >= netConnMaxBuffered {
+		nc.paused = true
+		nc.c.PauseRead()
+	}
+	nc.wake()
+}
+
+// 对端关闭了写或者连接已关闭，读完剩余的数据后返回io.EOF
+func (nc *NetConn) closeRead() {
+	nc.lock.Lock()
+	defer nc.lock.Unlock()
+
+	nc.eof = true
+	nc.wake()
+}
+
+// 唤醒阻塞的读写，需要持有锁
+func (nc *NetConn) wake() {
+	close(nc.notify)
+	nc.notify = make(chan struct{})
+}
+
+// 等待任意一个通道关闭，超过截止时间返回os.ErrDeadlineExceeded
+func waitDeadline(a, b <-chan struct{}, deadline time.Time) error {
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		d := time.Until(deadline)
+		if d <= 0 {
+			return os.ErrDeadlineExceeded
+		}
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case <-a:
+	case <-b:
+	case <-timeout:
+		return os.ErrDeadlineExceeded
+	}
+	return nil
+}
@@ -0,0 +1,27 @@
+package go_epoll
+
+import (
+	"os"
+	"testing"
+)
+
+// 监听失败时关闭创建的服务器，不泄漏文件描述符
+func TestListenErrorCloses(t *testing.T) {
+	count := func() int {
+		fds, err := os.ReadDir("/proc/self/fd")
+		if err != nil {
+			t.Skip(err)
+		}
+		return len(fds)
+	}
+	before := count()
+	for i := 0; i < 5; i++ {
+		//不是本机的地址，创建socket后bind失败
+		if _, err := Listen("192.0.2.1:0"); err == nil {
+			t.Fatal("listen on foreign address succeeded")
+		}
+	}
+	if after := count(); after != before {
+		t.Fatalf("fds = %d, want %d", after, before)
+	}
+}
@@ -0,0 +1,217 @@
+package go_epoll
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+// PROXY协议命令
+type ProxyCommand uint8
+
+const (
+	ProxyCommandLocal ProxyCommand = iota //上游自己发起的连接，如健康检查，使用真实的对端地址
+	ProxyCommandProxy                     //代理的连接，使用头部中的地址
+)
+
+// PROXY协议v2中常用的TLV类型
+const (
+	ProxyTLVTypeALPN      byte = 0x01 //应用层协议
+	ProxyTLVTypeAuthority byte = 0x02 //客户端请求的主机名，即SNI
+	ProxyTLVTypeCRC32C    byte = 0x03 //校验和
+	ProxyTLVTypeNoop      byte = 0x04 //填充
+	ProxyTLVTypeUniqueID  byte = 0x05 //连接唯一ID
+	ProxyTLVTypeSSL       byte = 0x20 //SSL信息
+	ProxyTLVTypeNetNS     byte = 0x30 //网络命名空间
+	ProxyTLVTypeAWS       byte = 0xEA //AWS扩展，如VPC终端节点ID
+)
+
+const (
+	proxyV1MaxLen    = 107 //v1头部最大长度
+	proxyV2HeaderLen = 16  //v2固定头部长度
+)
+
+var (
+	proxyV1Prefix    = []byte("PROXY ")
+	proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+)
+
+// PROXY协议v2中的TLV
+type ProxyTLV struct {
+	Type  byte   //类型
+	Value []byte //值
+}
+
+// PROXY协议头部
+type ProxyHeader struct {
+	Version int            //版本，1或2
+	Command ProxyCommand   //命令
+	SrcAddr netip.AddrPort //原始源地址，即真实的客户端地址
+	DstAddr netip.AddrPort //原始目的地址
+	TLVs    []ProxyTLV     //v2中的TLV
+}
+
+// 获取指定类型的TLV
+func (h *ProxyHeader) TLV(t byte) ([]byte, bool) {
+	for _, tlv := range h.TLVs {
+		if tlv.Type == t {
+			return tlv.Value, true
+		}
+	}
+	return nil, false
+}
+
+// 解析PROXY协议头部，返回头部以及头部的长度，数据不完整时返回DataNotEnough
+func ParseProxyHeader(b []byte) (*ProxyHeader, int, error) {
+	if hasPrefixPartial(b, proxyV2Signature) {
+		if len(b) < len(proxyV2Signature) {
+			return nil, 0, DataNotEnough
+		}
+		return parseProxyV2(b)
+	}
+	if hasPrefixPartial(b, proxyV1Prefix) {
+		if len(b) < len(proxyV1Prefix) {
+			return nil, 0, DataNotEnough
+		}
+		return parseProxyV1(b)
+	}
+	return nil, 0, ProxyHeaderInvalid
+}
+
+// 判断b是否以prefix开头，b比prefix短时，判断b是否是prefix的开头
+func hasPrefixPartial(b, prefix []byte) bool {
+	if len(b) < len(prefix) {
+		return bytes.HasPrefix(prefix, b)
+	}
+	return bytes.HasPrefix(b, prefix)
+}
+
+// 解析v1文本格式，如 "PROXY TCP4 192.168.0.1 192.168.0.11 56324 443\r\n"
+func parseProxyV1(b []byte) (*ProxyHeader, int, error) {
+	end := bytes.Index(b, []byte("\r\n"))
+	if end < 0 {
+		if len(b) >= proxyV1MaxLen {
+			return nil, 0, ProxyHeaderInvalid
+		}
+		return nil, 0, DataNotEnough
+	}
+	if end+2 > proxyV1MaxLen {
+		return nil, 0, ProxyHeaderInvalid
+	}
+
+	h := &ProxyHeader{Version: 1, Command: ProxyCommandProxy}
+	fields := strings.Split(string(b[:end]), " ")
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		//未知协议，使用真实的对端地址
+		h.Command = ProxyCommandLocal
+		return h, end + 2, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, 0, ProxyHeaderInvalid
+	}
+
+	src, err := parseProxyV1Addr(fields[2], fields[4])
+	if err != nil {
+		return nil, 0, err
+	}
+	dst, err := parseProxyV1Addr(fields[3], fields[5])
+	if err != nil {
+		return nil, 0, err
+	}
+	if (fields[1] == "TCP4") != src.Addr().Is4() || (fields[1] == "TCP4") != dst.Addr().Is4() {
+		return nil, 0, ProxyHeaderInvalid
+	}
+	h.SrcAddr = src
+	h.DstAddr = dst
+	return h, end + 2, nil
+}
+
+func parseProxyV1Addr(ip, port string) (netip.AddrPort, error) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return netip.AddrPort{}, ProxyHeaderInvalid
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return netip.AddrPort{}, ProxyHeaderInvalid
+	}
+	return netip.AddrPortFrom(addr, uint16(p)), nil
+}
+
+// 解析v2二进制格式
+func parseProxyV2(b []byte) (*ProxyHeader, int, error) {
+	if len(b) < proxyV2HeaderLen {
+		return nil, 0, DataNotEnough
+	}
+	verCmd := b[12]
+	if verCmd>>4 != 2 {
+		return nil, 0, ProxyHeaderInvalid
+	}
+	length := int(binary.BigEndian.Uint16(b[14:16]))
+	if len(b) < proxyV2HeaderLen+length {
+		return nil, 0, DataNotEnough
+	}
+	total := proxyV2HeaderLen + length
+	payload := b[proxyV2HeaderLen:total]
+
+	h := &ProxyHeader{Version: 2}
+	switch verCmd & 0x0F {
+	case 0x00:
+		h.Command = ProxyCommandLocal
+	case 0x01:
+		h.Command = ProxyCommandProxy
+	default:
+		return nil, 0, ProxyHeaderInvalid
+	}
+
+	//地址族，高4位为地址类型，低4位为传输协议
+	var addrLen int
+	switch b[13] >> 4 {
+	case 0x0:
+		//未指定地址
+		addrLen = 0
+	case 0x1:
+		addrLen = 12
+		if len(payload) < addrLen {
+			return nil, 0, ProxyHeaderInvalid
+		}
+		h.SrcAddr = netip.AddrPortFrom(netip.AddrFrom4([4]byte(payload[0:4])), binary.BigEndian.Uint16(payload[8:10]))
+		h.DstAddr = netip.AddrPortFrom(netip.AddrFrom4([4]byte(payload[4:8])), binary.BigEndian.Uint16(payload[10:12]))
+	case 0x2:
+		addrLen = 36
+		if len(payload) < addrLen {
+			return nil, 0, ProxyHeaderInvalid
+		}
+		h.SrcAddr = netip.AddrPortFrom(netip.AddrFrom16([16]byte(payload[0:16])), binary.BigEndian.Uint16(payload[32:34]))
+		h.DstAddr = netip.AddrPortFrom(netip.AddrFrom16([16]byte(payload[16:32])), binary.BigEndian.Uint16(payload[34:36]))
+	case 0x3:
+		//unix地址，没有IP，使用真实的对端地址
+		addrLen = 216
+		if len(payload) < addrLen {
+			return nil, 0, ProxyHeaderInvalid
+		}
+		h.Command = ProxyCommandLocal
+	default:
+		return nil, 0, ProxyHeaderInvalid
+	}
+
+	//解析TLV
+	tlvs := payload[addrLen:]
+	for len(tlvs) > 0 {
+		if len(tlvs) < 3 {
+			return nil, 0, ProxyHeaderInvalid
+		}
+		n := int(binary.BigEndian.Uint16(tlvs[1:3]))
+		if len(tlvs) < 3+n {
+			return nil, 0, ProxyHeaderInvalid
+		}
+		value := make([]byte, n)
+		copy(value, tlvs[3:3+n])
+		h.TLVs = append(h.TLVs, ProxyTLV{Type: tlvs[0], Value: value})
+		tlvs = tlvs[3+n:]
+	}
+
+	return h, total, nil
+}
@@ -0,0 +1,194 @@
+package go_epoll
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"net/netip"
+	"strings"
+	"testing"
+)
+
+// 构造v2头部，length为头部中声明的长度，小于0时使用payload的实际长度
+func proxyV2(verCmd, family byte, payload []byte, length int) []byte {
+	if length < 0 {
+		length = len(payload)
+	}
+	b := append([]byte{}, proxyV2Signature...)
+	b = append(b, verCmd, family, 0, 0)
+	binary.BigEndian.PutUint16(b[14:16], uint16(length))
+	return append(b, payload...)
+}
+
+// 构造TLV
+func proxyTLV(t byte, value []byte) []byte {
+	b := []byte{t, 0, 0}
+	binary.BigEndian.PutUint16(b[1:3], uint16(len(value)))
+	return append(b, value...)
+}
+
+func concat(bs ...[]byte) []byte {
+	return bytes.Join(bs, nil)
+}
+
+var (
+	proxyV2IPv4Addr = []byte{192, 168, 0, 1, 10, 0, 0, 1, 0xdc, 0x04, 0x01, 0xbb}
+	proxyV2IPv6Addr = concat(
+		netip.MustParseAddr("2001:db8::1").AsSlice(),
+		netip.MustParseAddr("2001:db8::2").AsSlice(),
+		[]byte{0xdc, 0x04, 0x01, 0xbb},
+	)
+)
+
+func TestParseProxyHeader(t *testing.T) {
+	v1 := "PROXY TCP4 192.168.0.1 10.0.0.1 56324 443\r\n"
+	v2IPv4 := proxyV2(0x21, 0x11, proxyV2IPv4Addr, -1)
+
+	tests := []struct {
+		name    string
+		input   []byte
+		err     error
+		n       int
+		version int
+		command ProxyCommand
+		src     string
+		dst     string
+		tlvs    []ProxyTLV
+	}{
+		//v1
+		{name: "v1 tcp4", input: []byte(v1 + "GET /"), n: len(v1), version: 1, command: ProxyCommandProxy, src: "192.168.0.1:56324", dst: "10.0.0.1:443"},
+		{name: "v1 tcp6", input: []byte("PROXY TCP6 2001:db8::1 2001:db8::2 56324 443\r\n"), n: 46, version: 1, command: ProxyCommandProxy, src: "[2001:db8::1]:56324", dst: "[2001:db8::2]:443"},
+		{name: "v1 unknown", input: []byte("PROXY UNKNOWN\r\n"), n: 15, version: 1, command: ProxyCommandLocal},
+		{name: "v1 unknown with addresses", input: []byte("PROXY UNKNOWN ffff::1 ffff::2 1 2\r\n"), n: 35, version: 1, command: ProxyCommandLocal},
+		{name: "v1 partial prefix", input: []byte("PRO"), err: DataNotEnough},
+		{name: "v1 truncated", input: []byte(v1[:len(v1)-2]), err: DataNotEnough},
+		{name: "v1 missing crlf", input: []byte("PROXY TCP4 192.168.0.1 10.0.0.1 56324 443\n"), err: DataNotEnough},
+		{name: "v1 oversized without crlf", input: []byte("PROXY TCP4 " + strings.Repeat("1", proxyV1MaxLen)), err: ProxyHeaderInvalid},
+		{name: "v1 oversized with crlf", input: []byte("PROXY TCP4 " + strings.Repeat("1", proxyV1MaxLen) + "\r\n"), err: ProxyHeaderInvalid},
+		{name: "v1 too few fields", input: []byte("PROXY TCP4 192.168.0.1 10.0.0.1 56324\r\n"), err: ProxyHeaderInvalid},
+		{name: "v1 too many fields", input: []byte("PROXY TCP4 192.168.0.1 10.0.0.1 56324 443 1\r\n"), err: ProxyHeaderInvalid},
+		{name: "v1 unknown protocol", input: []byte("PROXY UDP4 192.168.0.1 10.0.0.1 56324 443\r\n"), err: ProxyHeaderInvalid},
+		{name: "v1 bad source ip", input: []byte("PROXY TCP4 192.168.0 10.0.0.1 56324 443\r\n"), err: ProxyHeaderInvalid},
+		{name: "v1 bad port", input: []byte("PROXY TCP4 192.168.0.1 10.0.0.1 65536 443\r\n"), err: ProxyHeaderInvalid},
+		{name: "v1 negative port", input: []byte("PROXY TCP4 192.168.0.1 10.0.0.1 -1 443\r\n"), err: ProxyHeaderInvalid},
+		{name: "v1 family mismatch", input: []byte("PROXY TCP4 2001:db8::1 10.0.0.1 56324 443\r\n"), err: ProxyHeaderInvalid},
+		{name: "v1 double space", input: []byte("PROXY TCP4  192.168.0.1 10.0.0.1 56324 443\r\n"), err: ProxyHeaderInvalid},
+		{name: "not a proxy header", input: []byte("GET / HTTP/1.1\r\n"), err: ProxyHeaderInvalid},
+		{name: "empty", input: []byte{}, err: DataNotEnough},
+
+		//v2
+		{name: "v2 ipv4", input: concat(v2IPv4, []byte("data")), n: len(v2IPv4), version: 2, command: ProxyCommandProxy, src: "192.168.0.1:56324", dst: "10.0.0.1:443"},
+		{name: "v2 ipv6", input: proxyV2(0x21, 0x21, proxyV2IPv6Addr, -1), n: 16 + 36, version: 2, command: ProxyCommandProxy, src: "[2001:db8::1]:56324", dst: "[2001:db8::2]:443"},
+		{
+			name:    "v2 tlvs",
+			input:   proxyV2(0x21, 0x11, concat(proxyV2IPv4Addr, proxyTLV(ProxyTLVTypeAuthority, []byte("example.com")), proxyTLV(ProxyTLVTypeNoop, nil)), -1),
+			n:       16 + 12 + 14 + 3,
+			version: 2,
+			command: ProxyCommandProxy,
+			src:     "192.168.0.1:56324",
+			dst:     "10.0.0.1:443",
+			tlvs:    []ProxyTLV{{Type: ProxyTLVTypeAuthority, Value: []byte("example.com")}, {Type: ProxyTLVTypeNoop, Value: []byte{}}},
+		},
+		{name: "v2 local", input: proxyV2(0x20, 0x00, nil, -1), n: 16, version: 2, command: ProxyCommandLocal},
+		{name: "v2 local with addresses", input: proxyV2(0x20, 0x11, proxyV2IPv4Addr, -1), n: 28, version: 2, command: ProxyCommandLocal, src: "192.168.0.1:56324", dst: "10.0.0.1:443"},
+		{name: "v2 unix", input: proxyV2(0x21, 0x31, make([]byte, 216), -1), n: 16 + 216, version: 2, command: ProxyCommandLocal},
+		{name: "v2 partial signature", input: proxyV2Signature[:5], err: DataNotEnough},
+		{name: "v2 signature only", input: proxyV2Signature, err: DataNotEnough},
+		{name: "v2 truncated fixed header", input: v2IPv4[:15], err: DataNotEnough},
+		{name: "v2 truncated payload", input: v2IPv4[:len(v2IPv4)-1], err: DataNotEnough},
+		{name: "v2 oversized length", input: proxyV2(0x21, 0x11, proxyV2IPv4Addr, 0xFFFF), err: DataNotEnough},
+		{name: "v2 bad signature", input: concat([]byte("\r\n\r\n\x00\r\nQUIX\n"), v2IPv4[12:]), err: ProxyHeaderInvalid},
+		{name: "v2 bad version", input: proxyV2(0x11, 0x11, proxyV2IPv4Addr, -1), err: ProxyHeaderInvalid},
+		{name: "v2 bad command", input: proxyV2(0x22, 0x11, proxyV2IPv4Addr, -1), err: ProxyHeaderInvalid},
+		{name: "v2 bad family", input: proxyV2(0x21, 0x41, proxyV2IPv4Addr, -1), err: ProxyHeaderInvalid},
+		{name: "v2 short ipv4 address", input: proxyV2(0x21, 0x11, proxyV2IPv4Addr[:11], -1), err: ProxyHeaderInvalid},
+		{name: "v2 short ipv6 address", input: proxyV2(0x21, 0x21, proxyV2IPv4Addr, -1), err: ProxyHeaderInvalid},
+		{name: "v2 short unix address", input: proxyV2(0x21, 0x31, make([]byte, 215), -1), err: ProxyHeaderInvalid},
+		{name: "v2 truncated tlv header", input: proxyV2(0x21, 0x11, concat(proxyV2IPv4Addr, []byte{ProxyTLVTypeALPN, 0}), -1), err: ProxyHeaderInvalid},
+		{name: "v2 tlv length overflow", input: proxyV2(0x21, 0x11, concat(proxyV2IPv4Addr, []byte{ProxyTLVTypeALPN, 0, 5, 'h', '2'}), -1), err: ProxyHeaderInvalid},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, n, err := ParseProxyHeader(tt.input)
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("err = %v, want %v", err, tt.err)
+				}
+				if h != nil || n != 0 {
+					t.Fatalf("got header %+v, n = %d on error", h, n)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected err %v", err)
+			}
+			if n != tt.n {
+				t.Errorf("n = %d, want %d", n, tt.n)
+			}
+			if h.Version != tt.version {
+				t.Errorf("version = %d, want %d", h.Version, tt.version)
+			}
+			if h.Command != tt.command {
+				t.Errorf("command = %d, want %d", h.Command, tt.command)
+			}
+			if got := addrPortString(h.SrcAddr); got != tt.src {
+				t.Errorf("src = %q, want %q", got, tt.src)
+			}
+			if got := addrPortString(h.DstAddr); got != tt.dst {
+				t.Errorf("dst = %q, want %q", got, tt.dst)
+			}
+			if len(h.TLVs) != len(tt.tlvs) {
+				t.Fatalf("tlvs = %+v, want %+v", h.TLVs, tt.tlvs)
+			}
+			for i, tlv := range tt.tlvs {
+				if h.TLVs[i].Type != tlv.Type || !bytes.Equal(h.TLVs[i].Value, tlv.Value) {
+					t.Errorf("tlv[%d] = %+v, want %+v", i, h.TLVs[i], tlv)
+				}
+			}
+		})
+	}
+}
+
+func addrPortString(ap netip.AddrPort) string {
+	if !ap.IsValid() {
+		return ""
+	}
+	return ap.String()
+}
+
+// 数据逐字节到达时，头部完整之前都返回DataNotEnough
+func TestParseProxyHeaderIncremental(t *testing.T) {
+	inputs := [][]byte{
+		[]byte("PROXY TCP4 192.168.0.1 10.0.0.1 56324 443\r\n"),
+		proxyV2(0x21, 0x11, concat(proxyV2IPv4Addr, proxyTLV(ProxyTLVTypeALPN, []byte("h2"))), -1),
+	}
+	for _, input := range inputs {
+		for i := 0; i < len(input); i++ {
+			if _, _, err := ParseProxyHeader(input[:i]); err != DataNotEnough {
+				t.Fatalf("%q[:%d] err = %v, want DataNotEnough", input, i, err)
+			}
+		}
+		if _, n, err := ParseProxyHeader(input); err != nil || n != len(input) {
+			t.Fatalf("%q n = %d, err = %v", input, n, err)
+		}
+	}
+}
+
+// TLV的值是复制出来的，不引用输入的缓冲
+func TestParseProxyHeaderTLVCopy(t *testing.T) {
+	input := proxyV2(0x21, 0x11, concat(proxyV2IPv4Addr, proxyTLV(ProxyTLVTypeUniqueID, []byte("abc"))), -1)
+	h, _, err := ParseProxyHeader(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range input {
+		input[i] = 0
+	}
+	if v, ok := h.TLV(ProxyTLVTypeUniqueID); !ok || string(v) != "abc" {
+		t.Fatalf("TLV = %q, %v", v, ok)
+	}
+	if _, ok := h.TLV(ProxyTLVTypeSSL); ok {
+		t.Fatal("unexpected SSL TLV")
+	}
+}
@@ -0,0 +1,94 @@
+package go_epoll
+
+import (
+	"sync"
+	"time"
+)
+
+// 令牌桶
+type TokenBucket struct {
+	rate   float64    //每秒生成的令牌数
+	burst  float64    //桶容量
+	tokens float64    //当前令牌数
+	last   time.Time  //上次计算令牌的时间
+	lock   sync.Mutex //锁
+}
+
+func NewTokenBucket(rate float64, burst int) *TokenBucket {
+	if burst <= 0 {
+		burst = 1
+	}
+	return &TokenBucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// 修改速率与桶容量，并把桶装满，可在使用时调用，rate <= 0 表示不限制
+func (tb *TokenBucket) SetRate(rate float64, burst int) {
+	if burst <= 0 {
+		burst = 1
+	}
+	tb.lock.Lock()
+	defer tb.lock.Unlock()
+
+	tb.rate = rate
+	tb.burst = float64(burst)
+	tb.tokens = tb.burst
+	tb.last = time.Now()
+}
+
+// 按照流逝的时间补充令牌
+func (tb *TokenBucket) refill(now time.Time) {
+	elapsed := now.Sub(tb.last).Seconds()
+	if elapsed > 0 {
+		tb.tokens += elapsed * tb.rate
+		if tb.tokens > tb.burst {
+			tb.tokens = tb.burst
+		}
+	}
+	tb.last = now
+}
+
+// 尝试取一个令牌，取不到则返回false
+func (tb *TokenBucket) Allow() bool {
+	tb.lock.Lock()
+	defer tb.lock.Unlock()
+
+	if tb.rate <= 0 {
+		return true
+	}
+	tb.refill(time.Now())
+	if tb.tokens >= 1 {
+		tb.tokens--
+		return true
+	}
+	return false
+}
+
+// 预定一个令牌，返回需要等待多久才能使用该令牌
+func (tb *TokenBucket) Reserve() time.Duration {
+	tb.lock.Lock()
+	defer tb.lock.Unlock()
+
+	if tb.rate <= 0 {
+		return 0
+	}
+	tb.refill(time.Now())
+	tb.tokens--
+	if tb.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-tb.tokens / tb.rate * float64(time.Second))
+}
+
+// 桶是否已满，满了说明很久没有使用
+func (tb *TokenBucket) Full() bool {
+	tb.lock.Lock()
+	defer tb.lock.Unlock()
+
+	tb.refill(time.Now())
+	return tb.tokens >= tb.burst
+}
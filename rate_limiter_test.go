@@ -0,0 +1,93 @@
+package go_epoll
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTokenBucketAllow(t *testing.T) {
+	tests := []struct {
+		name    string
+		rate    float64
+		burst   int
+		allowed int //连续调用时允许的次数
+	}{
+		{"burst", 10, 3, 3},
+		{"zero burst", 10, 0, 1},
+		{"unlimited", 0, 1, 100},
+		{"negative rate", -1, 1, 100},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tb := NewTokenBucket(tt.rate, tt.burst)
+			n := 0
+			for i := 0; i < 100; i++ {
+				if tb.Allow() {
+					n++
+				}
+			}
+			if n != tt.allowed {
+				t.Fatalf("allowed = %d, want %d", n, tt.allowed)
+			}
+		})
+	}
+
+	//按照速率补充令牌
+	tb := NewTokenBucket(20, 1)
+	if !tb.Allow() || tb.Allow() {
+		t.Fatal("bucket of 1 allowed twice")
+	}
+	time.Sleep(60 * time.Millisecond)
+	if !tb.Allow() {
+		t.Fatal("token not refilled")
+	}
+}
+
+func TestTokenBucketReserve(t *testing.T) {
+	tests := []struct {
+		name  string
+		rate  float64
+		burst int
+		waits []time.Duration //连续预定时需要等待的时间
+	}{
+		{"burst", 10, 2, []time.Duration{0, 0, 100 * time.Millisecond, 200 * time.Millisecond}},
+		{"unlimited", 0, 1, []time.Duration{0, 0, 0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tb := NewTokenBucket(tt.rate, tt.burst)
+			for i, want := range tt.waits {
+				//调用之间流逝的时间会补充少量令牌
+				if d := tb.Reserve(); d > want || d < want-10*time.Millisecond {
+					t.Fatalf("reserve %d = %v, want %v", i, d, want)
+				}
+			}
+		})
+	}
+}
+
+// 修改速率时把桶装满
+func TestTokenBucketSetRate(t *testing.T) {
+	tb := NewTokenBucket(1, 1)
+	tb.Allow()
+	if tb.Full() {
+		t.Fatal("bucket full after Allow")
+	}
+	tb.SetRate(1, 3)
+	if !tb.Full() {
+		t.Fatal("bucket not full after SetRate")
+	}
+	for i := 0; i < 3; i++ {
+		if !tb.Allow() {
+			t.Fatalf("allow %d after SetRate failed", i)
+		}
+	}
+	if tb.Allow() {
+		t.Fatal("allowed over burst")
+	}
+	//不限制
+	tb.SetRate(0, 1)
+	if d := tb.Reserve(); d != 0 || !tb.Allow() {
+		t.Fatalf("unlimited reserve = %v", d)
+	}
+}
@@ -0,0 +1,285 @@
+package go_epoll
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+
+	"golang.org/x/sys/unix"
+)
+
+// 一次从socket读入管道的最大字节数，即管道默认容量
+const relayPipeSize = 64 << 10
+
+// 转发结束时的回调，aToB与bToA为两个方向转发的字节数，两个方向都正常结束时err为nil
+type RelayCallback func(aToB, bToA int64, err error)
+
+// 一个方向的转发，src读到的数据经过管道写入dst
+type relayDir struct {
+	src, dst *Conn
+	pipe     [2]int     //管道，0读1写
+	buffered int        //管道中还没有写入dst的字节数
+	sent     int64      //已经写入dst的字节数
+	eof      int32      //src已经关闭写
+	blocked  int32      //dst写不进去，暂停读取src
+	finished int32      //已经结束，并关闭了dst的写
+	started  int32      //src读缓冲中的数据已经发送到dst，之前不从src读取
+	closed   bool       //管道已关闭
+	lock     sync.Mutex //同一个方向的读写事件可能在不同的协程中处理
+}
+
+// 两个连接之间的转发
+type relay struct {
+	a, b     *Conn
+	ab, ba   *relayDir
+	callback RelayCallback
+	stopped  int32
+}
+
+// 在两个连接之间转发数据，使用splice经过管道在内核中复制，不经过用户空间
+// 一端关闭写之后，数据发送完时关闭另一端的写，两个方向都结束或者任意一端关闭时，关闭两个连接并调用callback
+// 一端写不进去时暂停读取另一端，由TCP流量控制反压，callback可以为nil
+// 开始转发之前已经读到还没有解码的数据会先发送，已经交给OnData的数据需要调用方自己处理
+// 开启了TLS的连接不能转发，设置的编码不会作用于转发的数据
+func Relay(a, b *Conn, callback RelayCallback) error {
+	if a == b || a.relay.Load() != nil || b.relay.Load() != nil {
+		return ConnInRelay
+	}
+	if a.tls != nil || b.tls != nil {
+		return RelayNotSupported
+	}
+	if a.IsClosed() || b.IsClosed() {
+		return ConnClosed
+	}
+
+	r := &relay{
+		a:        a,
+		b:        b,
+		ab:       &relayDir{src: a, dst: b},
+		ba:       &relayDir{src: b, dst: a},
+		callback: callback,
+	}
+	if err := unix.Pipe2(r.ab.pipe[:], unix.O_NONBLOCK|unix.O_CLOEXEC); err != nil {
+		return err
+	}
+	if err := unix.Pipe2(r.ba.pipe[:], unix.O_NONBLOCK|unix.O_CLOEXEC); err != nil {
+		r.ab.close()
+		return err
+	}
+
+	if !a.relay.CompareAndSwap(nil, r) {
+		r.ab.close()
+		r.ba.close()
+		return ConnInRelay
+	}
+	if !b.relay.CompareAndSwap(nil, r) {
+		a.relay.Store(nil)
+		r.ab.close()
+		r.ba.close()
+		return ConnInRelay
+	}
+
+	//任意一端在设置之前已经关闭
+	if a.IsClosed() || b.IsClosed() {
+		r.stop(ConnClosed)
+		return ConnClosed
+	}
+
+	r.start(r.ab)
+	r.start(r.ba)
+
+	return nil
+}
+
+// 在src的事件锁中先发送已经读到的数据，之后的数据通过管道转发
+// 在OnData中调用Relay时事件锁已经被持有，由Execute在回调返回后执行
+func (r *relay) start(d *relayDir) {
+	err := d.src.Execute(func() {
+		d.src.forwardBuffered(d.dst)
+		atomic.StoreInt32(&d.started, 1)
+		//内核中可能已经有数据，ET模式下不会再有事件
+		r.done(d.pump())
+	})
+	if err != nil {
+		r.stop(err)
+	}
+}
+
+// 把读缓冲中还没有解码的数据发送到dst，需要持有事件锁
+func (c *Conn) forwardBuffered(dst *Conn) {
+	if !c.incRef() {
+		return
+	}
+	defer c.decRef()
+
+	if c.readBuf.Len() == 0 {
+		return
+	}
+	dst.writeRaw(c.readBuf.Bytes())
+	c.readBuf.Clear()
+}
+
+// 获取以c为源与以c为目标的方向
+func (r *relay) dirs(c *Conn) (in, out *relayDir) {
+	if c == r.a {
+		return r.ab, r.ba
+	}
+	return r.ba, r.ab
+}
+
+// 转发模式下的事件处理
+func (r *relay) eventHandle(c *Conn, ev *Event) {
+	if ev.IsError() {
+		err := c.socketErr()
+		if err == nil {
+			err = ConnSocketError
+		}
+		c.eventHandleError(err)
+		c.closeWithErr(err)
+		return
+	}
+
+	in, out := r.dirs(c)
+	var err error
+	//可写，先发送写缓冲中的数据，再发送管道中的数据
+	if ev.IsWrite() {
+		c.flush()
+		err = out.pump()
+	}
+	//可读或者对端已关闭
+	if err == nil && (ev.IsRead() || ev.IsClose()) {
+		err = in.pump()
+	}
+	r.done(err)
+}
+
+// 处理完一次事件，出错或者两个方向都结束时停止，否则重新注册两个连接的事件
+func (r *relay) done(err error) {
+	if err != nil {
+		r.stop(err)
+		return
+	}
+	if atomic.LoadInt32(&r.ab.finished) == 1 && atomic.LoadInt32(&r.ba.finished) == 1 {
+		r.stop(nil)
+		return
+	}
+	r.a.rearm()
+	r.b.rearm()
+}
+
+// 获取连接需要监听的事件，src已经关闭或者管道中的数据写不进去时不读，管道中有数据写不进去时监听可写
+func (r *relay) interest(c *Conn) (read, write bool) {
+	in, out := r.dirs(c)
+	read = atomic.LoadInt32(&in.started) == 1 && atomic.LoadInt32(&in.eof) == 0 && atomic.LoadInt32(&in.blocked) == 0
+	write = atomic.LoadInt32(&out.blocked) == 1
+	return read, write
+}
+
+// 停止转发，关闭两个连接并回调，只执行一次
+func (r *relay) stop(err error) {
+	if !atomic.CompareAndSwapInt32(&r.stopped, 0, 1) {
+		return
+	}
+	r.a.closeWithErr(err)
+	r.b.closeWithErr(err)
+	r.ab.close()
+	r.ba.close()
+
+	if r.callback != nil {
+		r.callback(atomic.LoadInt64(&r.ab.sent), atomic.LoadInt64(&r.ba.sent), err)
+	}
+}
+
+// 在src与dst之间转发，直到src没有数据或者dst写不进去，返回的错误需要停止转发
+func (d *relayDir) pump() error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	//还没有发送读缓冲中的数据，或者已经结束
+	if d.closed || atomic.LoadInt32(&d.started) == 0 || atomic.LoadInt32(&d.finished) == 1 {
+		return nil
+	}
+	//任意一端已经关闭时转发已经停止
+	if !d.src.incRef() {
+		return nil
+	}
+	defer d.src.decRef()
+	if !d.dst.incRef() {
+		return nil
+	}
+	defer d.dst.decRef()
+
+	flags := unix.SPLICE_F_MOVE | unix.SPLICE_F_NONBLOCK
+	for {
+		//先把管道中的数据写入dst，dst的写缓冲中还有数据时等待写缓冲发送完
+		for d.buffered > 0 {
+			if atomic.LoadInt64(&d.dst.writeStart) != 0 {
+				atomic.StoreInt32(&d.blocked, 1)
+				return nil
+			}
+			size := d.buffered
+			if size > maxWritevSize {
+				size = maxWritevSize
+			}
+			n, err := unix.Splice(d.pipe[0], nil, d.dst.fd, nil, size, flags)
+			if err != nil {
+				if err == unix.EINTR {
+					continue
+				}
+				//dst写不进去，暂停读取src，等待dst可写
+				if err == unix.EAGAIN || err == unix.EWOULDBLOCK {
+					atomic.StoreInt32(&d.blocked, 1)
+					return nil
+				}
+				d.dst.logger().Error(context.Background(), "relay splice to conn error : ", err.Error())
+				return err
+			}
+			d.buffered -= int(n)
+			atomic.AddInt64(&d.sent, n)
+
+			d.dst.touch()
+		}
+		atomic.StoreInt32(&d.blocked, 0)
+
+		//src已经关闭写，数据发送完之后关闭dst的写
+		if atomic.LoadInt32(&d.eof) == 1 {
+			unix.Shutdown(d.dst.fd, unix.SHUT_WR)
+			atomic.StoreInt32(&d.finished, 1)
+			return nil
+		}
+
+		//管道为空时才从src读取，管道不会满
+		n, err := unix.Splice(d.src.fd, nil, d.pipe[1], nil, relayPipeSize, flags)
+		if err != nil {
+			if err == unix.EINTR {
+				continue
+			}
+			//src中没有数据，等待可读
+			if err == unix.EAGAIN || err == unix.EWOULDBLOCK {
+				return nil
+			}
+			d.src.logger().Error(context.Background(), "relay splice from conn error : ", err.Error())
+			return err
+		}
+		if n == 0 {
+			atomic.StoreInt32(&d.eof, 1)
+			continue
+		}
+		d.buffered += int(n)
+
+		d.src.touch()
+	}
+}
+
+// 关闭管道
+func (d *relayDir) close() {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if d.closed {
+		return
+	}
+	d.closed = true
+	unix.Close(d.pipe[0])
+	unix.Close(d.pipe[1])
+}
@@ -0,0 +1,98 @@
+package go_epoll
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// 按行解码，测试转发之前读缓冲中剩余的数据
+type testLineCodec struct{}
+
+func (testLineCodec) Encode(data []byte) ([]byte, error) {
+	return data, nil
+}
+
+func (testLineCodec) Decode(buf *Buffer) ([]byte, error) {
+	i := bytes.IndexByte(buf.Bytes(), '\n')
+	if i < 0 {
+		return nil, DataNotEnough
+	}
+	return buf.ReadAt(0, i+1)
+}
+
+// 在OnData中开始转发，读缓冲中剩余的数据先发送，之后的数据经过管道转发，两个方向结束后回调
+func TestRelay(t *testing.T) {
+	bl, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bl.Close()
+
+	backend := make(chan *Conn, 1)
+	type result struct {
+		aToB, bToA int64
+		err        error
+	}
+	done := make(chan result, 1)
+	h := &testHandler{
+		onConnect: func(c *Conn) {
+			d := NewDialer(c.server, time.Second)
+			d.Handler = &testHandler{onConnect: func(b *Conn) { backend <- b }}
+			if _, err := d.Dial("tcp", bl.Addr().String()); err != nil {
+				t.Error(err)
+			}
+		},
+		onData: func(c *Conn, data []byte) {
+			if string(data) != "CONNECT\n" {
+				t.Errorf("OnData = %q", data)
+				return
+			}
+			err := Relay(c, <-backend, func(aToB, bToA int64, err error) {
+				done <- result{aToB, bToA, err}
+			})
+			if err != nil {
+				t.Error(err)
+			}
+		},
+	}
+	s, err := NewTcpServer("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.SetHandler(h)
+	s.SetEnDecoder(testLineCodec{})
+	addr := serveTestServer(t, s)
+	c := dialTestServer(t, addr)
+	c.Write([]byte("CONNECT\nabc"))
+
+	bc, err := bl.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bc.Close()
+	buf := make([]byte, 3)
+	if _, err = io.ReadFull(bc, buf); err != nil || string(buf) != "abc" {
+		t.Fatalf("backend read = %q %v", buf, err)
+	}
+
+	c.Write([]byte("def"))
+	c.(*net.TCPConn).CloseWrite()
+	rest, err := io.ReadAll(bc)
+	if err != nil || string(rest) != "def" {
+		t.Fatalf("backend read = %q %v", rest, err)
+	}
+	bc.Write([]byte("pong"))
+	bc.(*net.TCPConn).CloseWrite()
+
+	got, err := io.ReadAll(c)
+	if err != nil || string(got) != "pong" {
+		t.Fatalf("client read = %q %v", got, err)
+	}
+	r := waitChan(t, done)
+	if r.err != nil || r.aToB != 3 || r.bToA != 4 {
+		t.Fatalf("callback = %+v", r)
+	}
+}
@@ -0,0 +1,199 @@
+package go_epoll
+
+import (
+	"golang.org/x/sys/unix"
+	"time"
+)
+
+// socket选项，监听时与接收连接时使用
+type SockOptions struct {
+	ReuseAddr         *bool         `json:"reuse_addr"`          //SO_REUSEADDR，重用地址，nil表示使用默认值开启
+	ReusePort         *bool         `json:"reuse_port"`          //SO_REUSEPORT，重用端口，nil表示使用默认值开启
+	Backlog           int           `json:"backlog"`             //监听队列长度，0表示使用默认值1024
+	NoDelay           bool          `json:"no_delay"`            //TCP_NODELAY，禁用Nagle算法
+	KeepAlive         bool          `json:"keep_alive"`          //SO_KEEPALIVE，开启TCP保活
+	KeepAliveIdle     time.Duration `json:"keep_alive_idle"`     //TCP_KEEPIDLE，连接空闲多久后开始发送保活探测
+	KeepAliveInterval time.Duration `json:"keep_alive_interval"` //TCP_KEEPINTVL，保活探测间隔
+	KeepAliveCount    int           `json:"keep_alive_count"`    //TCP_KEEPCNT，保活探测次数
+	RecvBuf           int           `json:"recv_buf"`            //SO_RCVBUF，内核读缓冲大小，0表示使用系统默认值
+	SendBuf           int           `json:"send_buf"`            //SO_SNDBUF，内核写缓冲大小，0表示使用系统默认值
+	UserTimeout       time.Duration `json:"user_timeout"`        //TCP_USER_TIMEOUT，已发送数据多久未被确认就关闭连接
+	DeferAccept       time.Duration `json:"defer_accept"`        //TCP_DEFER_ACCEPT，连接建立后有数据到达才唤醒accept
+	FastOpen          int           `json:"fast_open"`           //TCP_FASTOPEN，TFO队列长度，0表示不开启
+	Linger            bool          `json:"linger"`              //SO_LINGER，是否开启linger
+	LingerTime        time.Duration `json:"linger_time"`         //linger时间，为0时关闭连接会发送RST
+}
+
+// 默认socket选项
+func DefaultSockOptions() *SockOptions {
+	return &SockOptions{
+		ReuseAddr: Bool(true),
+		ReusePort: Bool(true),
+		Backlog:   1024,
+	}
+}
+
+// 返回b的指针，用于设置ReuseAddr等可以不设置的选项
+func Bool(b bool) *bool {
+	return &b
+}
+
+// 复制选项，没有设置的字段使用DefaultSockOptions中的值
+func (o *SockOptions) withDefaults() *SockOptions {
+	def := DefaultSockOptions()
+	opts := *o
+	if opts.ReuseAddr == nil {
+		opts.ReuseAddr = def.ReuseAddr
+	}
+	if opts.ReusePort == nil {
+		opts.ReusePort = def.ReusePort
+	}
+	if opts.Backlog <= 0 {
+		opts.Backlog = def.Backlog
+	}
+	return &opts
+}
+
+// 获取监听队列长度
+func (o *SockOptions) backlog() int {
+	if o.Backlog <= 0 {
+		return 1024
+	}
+	return o.Backlog
+}
+
+// 在监听socket上设置选项，需要在bind之前调用
+func (o *SockOptions) applyListen(fd int) error {
+	if o.ReuseAddr == nil || *o.ReuseAddr {
+		if err := unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_REUSEADDR, 1); err != nil {
+			return err
+		}
+	}
+	if o.ReusePort == nil || *o.ReusePort {
+		if err := unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_REUSEPORT, 1); err != nil {
+			return err
+		}
+	}
+	// 在监听socket上设置缓冲大小，接收的连接会继承，窗口扩大选项需要在握手时确定
+	if err := setBuffer(fd, o.RecvBuf, o.SendBuf); err != nil {
+		return err
+	}
+	if o.DeferAccept > 0 {
+		if err := unix.SetsockoptInt(fd, unix.IPPROTO_TCP, unix.TCP_DEFER_ACCEPT, durationToSec(o.DeferAccept)); err != nil {
+			return err
+		}
+	}
+	if o.FastOpen > 0 {
+		if err := unix.SetsockoptInt(fd, unix.IPPROTO_TCP, unix.TCP_FASTOPEN, o.FastOpen); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 在接收的连接上设置选项
+func (o *SockOptions) applyConn(fd int) error {
+	if o.NoDelay {
+		if err := setNoDelay(fd, true); err != nil {
+			return err
+		}
+	}
+	if o.KeepAlive {
+		if err := setKeepAlive(fd, true); err != nil {
+			return err
+		}
+		if err := setKeepAlivePeriod(fd, o.KeepAliveIdle, o.KeepAliveInterval, o.KeepAliveCount); err != nil {
+			return err
+		}
+	}
+	if err := setBuffer(fd, o.RecvBuf, o.SendBuf); err != nil {
+		return err
+	}
+	if o.UserTimeout > 0 {
+		if err := setUserTimeout(fd, o.UserTimeout); err != nil {
+			return err
+		}
+	}
+	if o.Linger {
+		if err := setLinger(fd, durationToSec(o.LingerTime)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 设置TCP_NODELAY
+func setNoDelay(fd int, noDelay bool) error {
+	return unix.SetsockoptInt(fd, unix.IPPROTO_TCP, unix.TCP_NODELAY, boolToInt(noDelay))
+}
+
+// 设置SO_KEEPALIVE
+func setKeepAlive(fd int, keepAlive bool) error {
+	return unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_KEEPALIVE, boolToInt(keepAlive))
+}
+
+// 设置保活探测参数，值为0的参数使用系统默认值
+func setKeepAlivePeriod(fd int, idle, interval time.Duration, count int) error {
+	if idle > 0 {
+		if err := unix.SetsockoptInt(fd, unix.IPPROTO_TCP, unix.TCP_KEEPIDLE, durationToSec(idle)); err != nil {
+			return err
+		}
+	}
+	if interval > 0 {
+		if err := unix.SetsockoptInt(fd, unix.IPPROTO_TCP, unix.TCP_KEEPINTVL, durationToSec(interval)); err != nil {
+			return err
+		}
+	}
+	if count > 0 {
+		if err := unix.SetsockoptInt(fd, unix.IPPROTO_TCP, unix.TCP_KEEPCNT, count); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 设置SO_RCVBUF与SO_SNDBUF，值为0的参数使用系统默认值
+func setBuffer(fd int, recvBuf, sendBuf int) error {
+	if recvBuf > 0 {
+		if err := unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_RCVBUF, recvBuf); err != nil {
+			return err
+		}
+	}
+	if sendBuf > 0 {
+		if err := unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_SNDBUF, sendBuf); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 设置TCP_USER_TIMEOUT
+func setUserTimeout(fd int, timeout time.Duration) error {
+	return unix.SetsockoptInt(fd, unix.IPPROTO_TCP, unix.TCP_USER_TIMEOUT, int(timeout/time.Millisecond))
+}
+
+// 设置SO_LINGER，sec < 0 表示关闭linger
+func setLinger(fd int, sec int) error {
+	l := &unix.Linger{}
+	if sec >= 0 {
+		l.Onoff = 1
+		l.Linger = int32(sec)
+	}
+	return unix.SetsockoptLinger(fd, unix.SOL_SOCKET, unix.SO_LINGER, l)
+}
+
+// 时间转换成秒，不足1秒按1秒算
+func durationToSec(d time.Duration) int {
+	sec := int((d + time.Second - 1) / time.Second)
+	if sec < 0 {
+		return 0
+	}
+	return sec
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
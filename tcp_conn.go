@@ -1,157 +1,716 @@
 package go_epoll
 
 import (
+	"bytes"
 	"context"
+	"crypto/tls"
+	"fmt"
 	"golang.org/x/sys/unix"
 	"io"
+	"net"
+	"net/netip"
 	"sync"
 	"sync/atomic"
+	"time"
 )
 
+// 最后分配的连接ID
+var connID uint64
+
 type Conn struct {
-	fd       int         //文件描述符
-	addr     string      //地址
-	isClose  int32       //0正常，1关闭
-	server   *TcpServer  //服务器指针
-	rbuf     []byte      //读缓冲
-	wbuf     []byte      //写缓冲
-	readBuf  *Buffer     //从fd中读取的数据
-	writeBuf *Buffer     //从fd中写入的数据
-	rLock    *sync.Mutex //读锁
-	wLock    *sync.Mutex //写锁
-	ext      interface{} //扩展数据
+	id        uint64        //连接ID，单调递增，不会复用
+	fd        int           //文件描述符
+	addr      string        //地址，开启PROXY协议时为真实的客户端地址
+	peerAddr  string        //socket对端地址
+	ip        netip.Addr    //socket对端IP
+	refs      int32         //引用计数，没有关闭时为1加上正在进行的操作数
+	freed     int32         //1表示已经关闭fd并归还读缓冲
+	readShut  int32         //1表示读已经关闭
+	writeShut int32         //写关闭的状态
+	connected int32         //是否已经调用了OnConnect
+	closing   int32         //1表示调用了CloseAfterFlush
+	outbound  bool          //是否是通过Dialer发起的连接
+	dialing   int32         //1表示正在连接
+	deadline  int64         //连接超时的时间，纳秒，0表示不限制
+	tls       *tlsTransport //TLS传输层，未开启TLS时为nil
+	proxy     bool          //是否等待PROXY协议头部
+	proxyBuf  []byte        //未解析完的PROXY协议头部
+	proxyHdr  *ProxyHeader  //PROXY协议头部
+	admission *Admission    //接收时检查的准入控制，关闭时在它上面释放
+	//关闭相关
+	closed atomic.Pointer[closeState] //关闭原因，nil表示没有关闭，与关闭标记一起发布
+	//超时相关，时间都为纳秒
+	idleTimeout  int64 //空闲超时时间
+	readTimeout  int64 //读超时时间
+	writeTimeout int64 //写超时时间
+	lastActive   int64 //最后活动时间
+	readStart    int64 //开始接收一条消息的时间，0表示没有未收完的消息
+	writeStart   int64 //写缓冲开始有数据的时间，0表示写缓冲为空
+	flushClose   int64 //调用CloseAfterFlush后关闭的最晚时间，0表示没有调用
+	//写缓冲水位相关
+	highWatermark    int64         //高水位
+	lowWatermark     int64         //低水位
+	writeFullPolicy  int32         //超过高水位时Write的处理策略
+	writeFullTimeout int64         //超过高水位的最长时间，纳秒
+	writeFullStart   int64         //超过高水位的时间，0表示没有超过
+	writeFull        bool          //是否超过了高水位，需要持有写锁
+	writable         chan struct{} //降到低水位以下时关闭，需要持有写锁
+	//心跳相关
+	heartbeat   atomic.Pointer[HeartbeatConfig] //心跳配置
+	lastBeat    int64                           //最后收到数据的时间
+	missedBeats int32                           //连续丢失的心跳次数
+	relay       atomic.Pointer[relay]           //转发，nil表示没有转发
+	server      *TcpServer                      //服务器指针
+	log         *Logger                         //带有conn_id字段的日志
+	handler     TcpServerHandler                //回调函数
+	endecoder   EnDecoder                       //编码解码
+	rbuf        []byte                          //读缓冲
+	readBuf     *Buffer                         //从fd中读取的数据
+	inOnData    int32                           //1表示正在调用OnData，可以调用Retain
+	readPaused  int32                           //1表示暂停了读取
+	eventLock   sync.Mutex                      //事件处理的锁，同一个连接的读事件处理与Execute按顺序执行，发送不需要
+	readWaiting int32                           //等待事件锁的事件数量，大于0时重新注册不监听读
+	tasks       []func()                        //Execute提交的还没有执行的函数
+	taskRunning bool                            //是否已经有协程在执行tasks
+	taskLock    sync.Mutex                      //tasks的锁
+	retained    *Retained                       //OnData中调用Retain取走的缓冲
+	writeQueue  *writeQueue                     //待发送的数据
+	writeNotify []func()                        //释放写锁之后需要调用的发送回调
+	//零拷贝发送相关，需要持有写锁
+	zeroCopy        int32            //SO_ZEROCOPY的状态
+	zeroCopySeq     uint32           //下一次使用MSG_ZEROCOPY发送的序号
+	zeroCopyPending []*zeroCopyFrame //还没有收到完成通知的发送
+	drain           chan struct{}    //写缓冲发送完时关闭，用于等待发送完成
+	rLock           *sync.Mutex      //读锁
+	wLock           *sync.Mutex      //写锁
+	armLock         sync.Mutex       //重新注册事件的锁
+	done            chan struct{}    //连接关闭时关闭
+	//上下文与属性
+	ctx    context.Context         //连接关闭时取消
+	cancel context.CancelCauseFunc //取消ctx，原因为关闭的错误
+	attrs  sync.Map                //属性，包括SetExt设置的扩展数据
 }
 
 func NewConn(fd int, addr string, s *TcpServer) (*Conn, error) {
-	conn := &Conn{
-		fd:       fd,
-		addr:     addr,
-		isClose:  0,
-		server:   s,
-		rbuf:     make([]byte, 1024),
-		wbuf:     make([]byte, 1024),
-		readBuf:  s.bufPool.Get().(*Buffer),
-		writeBuf: s.bufPool.Get().(*Buffer),
-		rLock:    &sync.Mutex{},
-		wLock:    &sync.Mutex{},
+	return newAcceptedConn(fd, addr, s, nil)
+}
+
+// 创建接收的连接，admission为接收时已经通过检查的准入控制
+func newAcceptedConn(fd int, addr string, s *TcpServer, admission *Admission) (*Conn, error) {
+	conn := newConn(fd, addr, s)
+	conn.admission = admission
+	conn.heartbeat.Store(s.cfg.Heartbeat)
+
+	if s.cfg.TLSConfig != nil {
+		conn.tls = newTLSTransport(conn, s.cfg.TLSConfig)
 	}
 
-	//新来的连接，往反应堆里添加读事件，注意这里使用ET模式
-	err := s.reactor.AddHandler(Event{
-		Fd:        fd,
-		EventType: EventRead | EventError | EventET | EventOneShot,
-	}, conn.eventHandle)
+	//来自可信上游的连接，先解析PROXY协议头部
+	conn.proxy = s.expectProxyHeader(conn.ip)
 
-	if err != nil {
-		logger.Error(context.Background(), "reactor AddHandler error : ", err.Error())
+	//新来的连接，往反应堆里添加读事件，注意这里使用ET模式
+	if err := conn.register(); err != nil {
+		conn.logger().Error(context.Background(), "reactor AddHandler error : ", err.Error())
 		return nil, err
 	}
 
-	//处理连接回调
-	s.handler.OnConnect(conn)
+	//放入时间轮，检查超时
+	conn.scheduleTimeout()
+
+	//需要解析PROXY协议头部时，解析完成后才开始
+	if !conn.proxy {
+		conn.start()
+	}
 
 	return conn, nil
 }
 
-// 获取文件描述符
+// 创建连接结构，不注册事件
+func newConn(fd int, addr string, s *TcpServer) *Conn {
+	now := time.Now().UnixNano()
+	c := &Conn{
+		id:         atomic.AddUint64(&connID, 1),
+		fd:         fd,
+		addr:       addr,
+		peerAddr:   addr,
+		ip:         ParseIP(addr),
+		refs:       1,
+		server:     s,
+		handler:    s.handler,
+		endecoder:  s.endecoder,
+		rbuf:       make([]byte, s.cfg.ReadChunkSize),
+		readBuf:    s.bufPool.Get().(*Buffer),
+		writeQueue: newWriteQueue(s.cfg.WriteChunkSize),
+		rLock:      &sync.Mutex{},
+		wLock:      &sync.Mutex{},
+
+		idleTimeout:  int64(s.cfg.IdleTimeout),
+		readTimeout:  int64(s.cfg.ReadTimeout),
+		writeTimeout: int64(s.cfg.WriteTimeout),
+		lastActive:   now,
+		lastBeat:     now,
+
+		writeFullPolicy:  int32(s.cfg.WriteFullPolicy),
+		writeFullTimeout: int64(s.cfg.WriteFullTimeout),
+		done:             make(chan struct{}),
+	}
+	c.log = s.getLogger().WithFields(LogFields{"conn_id": c.id})
+	c.ctx, c.cancel = context.WithCancelCause(context.Background())
+	c.SetWriteWatermark(s.cfg.WriteHighWatermark, s.cfg.WriteLowWatermark)
+	return c
+}
+
+// 往反应堆里注册读事件
+func (c *Conn) register() error {
+	return c.server.reactor.AddHandler(Event{
+		Fd:        c.fd,
+		EventType: EventRead | EventError | EventET | EventOneShot,
+	}, c.eventHandle)
+}
+
+// 清空写缓冲，返回还没有发送完的文件等，需要持有写锁
+func (c *Conn) release() []sendFrame {
+	others := c.writeQueue.reset()
+	//已经发送完还没有收到完成通知的零拷贝发送
+	for _, zf := range c.zeroCopyPending {
+		others = append(others, zf)
+	}
+	c.zeroCopyPending = nil
+	return others
+}
+
+// 开始处理连接
+func (c *Conn) start() {
+	if c.tls != nil {
+		//开启了TLS，握手完成后才调用连接回调
+		go c.tls.handshake()
+	} else {
+		c.onConnect()
+	}
+}
+
+// 处理连接回调
+func (c *Conn) onConnect() {
+	if atomic.CompareAndSwapInt32(&c.connected, 0, 1) {
+		c.handler.OnConnect(c)
+	}
+}
+
+// 获取文件描述符，关闭后会被新连接复用，需要保存时使用ID
 func (c *Conn) GetFD() int {
 	return c.fd
 }
 
-// 获取地址
+// 获取连接ID，进程内单调递增，从1开始，不会复用
+func (c *Conn) ID() uint64 {
+	return c.id
+}
+
+// 带有连接ID的日志，创建连接时生成，不需要每次添加字段
+func (c *Conn) logger() *Logger {
+	return c.log
+}
+
+// 获取地址，开启PROXY协议时为头部中的真实客户端地址
 func (c *Conn) GetAddr() string {
 	return c.addr
 }
 
-// 设置扩展数据
+// 获取socket对端地址，开启PROXY协议时为上游代理的地址
+func (c *Conn) GetPeerAddr() string {
+	return c.peerAddr
+}
+
+// 是否是通过Dialer发起的连接
+func (c *Conn) IsOutbound() bool {
+	return c.outbound
+}
+
+// 获取本端地址
+func (c *Conn) LocalAddr() net.Addr {
+	if !c.incRef() {
+		return nil
+	}
+	defer c.decRef()
+
+	sa, err := unix.Getsockname(c.fd)
+	if err != nil {
+		return nil
+	}
+	return GetNetAddrBySockAddr(sa)
+}
+
+// 获取对端地址，开启PROXY协议时为头部中的真实客户端地址
+func (c *Conn) RemoteAddr() net.Addr {
+	if addrPort := ParseAddrPort(c.addr); addrPort.IsValid() {
+		return net.TCPAddrFromAddrPort(addrPort)
+	}
+	return &net.UnixAddr{Name: c.addr, Net: "unix"}
+}
+
+// 获取PROXY协议头部，包括原始的源地址、目的地址与TLV，没有时返回nil
+func (c *Conn) ProxyHeader() *ProxyHeader {
+	return c.proxyHdr
+}
+
+// 连接是否已关闭
+func (c *Conn) IsClosed() bool {
+	return c.closed.Load() != nil
+}
+
+// 设置扩展数据，可以在多个协程中调用，需要保存多个数据时使用SetAttr
 func (c *Conn) SetExt(ext interface{}) {
-	c.ext = ext
+	c.attrs.Store(extKey{}, ext)
 }
 
 // 获取扩展数据
 func (c *Conn) GetExt() interface{} {
-	return c.ext
+	ext, _ := c.attrs.Load(extKey{})
+	return ext
+}
+
+// 设置TCP_NODELAY
+func (c *Conn) SetNoDelay(noDelay bool) error {
+	return c.control(func(fd int) error {
+		return setNoDelay(fd, noDelay)
+	})
 }
 
-// 读数据
+// 设置TCP保活
+func (c *Conn) SetKeepAlive(keepAlive bool) error {
+	return c.control(func(fd int) error {
+		return setKeepAlive(fd, keepAlive)
+	})
+}
+
+// 设置保活探测参数，值为0的参数使用系统默认值
+func (c *Conn) SetKeepAlivePeriod(idle, interval time.Duration, count int) error {
+	return c.control(func(fd int) error {
+		return setKeepAlivePeriod(fd, idle, interval, count)
+	})
+}
+
+// 设置内核读缓冲大小
+func (c *Conn) SetReadBuffer(bytes int) error {
+	return c.control(func(fd int) error {
+		return setBuffer(fd, bytes, 0)
+	})
+}
+
+// 设置内核写缓冲大小
+func (c *Conn) SetWriteBuffer(bytes int) error {
+	return c.control(func(fd int) error {
+		return setBuffer(fd, 0, bytes)
+	})
+}
+
+// 设置TCP_USER_TIMEOUT
+func (c *Conn) SetUserTimeout(timeout time.Duration) error {
+	return c.control(func(fd int) error {
+		return setUserTimeout(fd, timeout)
+	})
+}
+
+// 设置SO_LINGER，sec < 0 表示关闭linger，sec = 0 时关闭连接会发送RST
+func (c *Conn) SetLinger(sec int) error {
+	return c.control(func(fd int) error {
+		return setLinger(fd, sec)
+	})
+}
+
+// 获取TLS连接状态，如协商的协议、SNI与客户端证书，未开启TLS或握手未完成时返回false
+func (c *Conn) TLSConnectionState() (tls.ConnectionState, bool) {
+	if c.tls == nil {
+		return tls.ConnectionState{}, false
+	}
+	state := c.tls.conn.ConnectionState()
+	return state, state.HandshakeComplete
+}
+
+// 读数据，连接已关闭时返回ConnClosed
 func (c *Conn) Read(p []byte) (int, error) {
+	if !c.incRef() {
+		return 0, ConnClosed
+	}
+	defer c.decRef()
+
 	c.rLock.Lock()
 	defer c.rLock.Unlock()
 
 	return c.readBuf.Read(p)
 }
 
-// 写数据
+// 写数据，连接已关闭时返回ConnClosed
 func (c *Conn) Write(p []byte) (int, error) {
-	c.wLock.Lock()
-	defer c.wLock.Unlock()
+	if c.IsClosed() {
+		return 0, ConnClosed
+	}
+	//超过高水位，在加密之前检查，TLS连接写入失败后无法恢复
+	if err := c.waitWritable(); err != nil {
+		return 0, err
+	}
 
-	if c.server.endecoder != nil {
-		encode, err := c.server.endecoder.Encode(p)
+	if c.endecoder != nil {
+		encode, err := c.endecoder.Encode(p)
 		if err != nil {
 			return 0, err
 		}
 		p = encode
 	}
 
-	n, err := c.writeBuf.Write(p)
+	//开启了TLS，加密后再写入
+	if c.tls != nil {
+		return c.tls.write(p)
+	}
+
+	return c.writeRaw(p)
+}
+
+// 发送多个缓冲，如头部与内容，不需要先合并到一起
+// 设置了编码时会先合并再编码，开启了TLS时每个缓冲单独加密
+func (c *Conn) WriteBuffers(bufs [][]byte) (int, error) {
+	if c.IsClosed() {
+		return 0, ConnClosed
+	}
+	if err := c.waitWritable(); err != nil {
+		return 0, err
+	}
+
+	if c.endecoder != nil {
+		encode, err := c.endecoder.Encode(bytes.Join(bufs, nil))
+		if err != nil {
+			return 0, err
+		}
+		bufs = [][]byte{encode}
+	}
+
+	if c.tls != nil {
+		total := 0
+		for _, b := range bufs {
+			n, err := c.tls.write(b)
+			total += n
+			if err != nil {
+				return total, err
+			}
+		}
+		return total, nil
+	}
+
+	return c.writeRaw(bufs...)
+}
+
+// 发送数据，没有发送完的复制到写缓冲中，并等待可写事件
+func (c *Conn) writeRaw(bufs ...[]byte) (int, error) {
+	total := 0
+	for _, b := range bufs {
+		total += len(b)
+	}
+
+	if !c.incRef() {
+		return 0, ConnClosed
+	}
+	defer c.decRef()
+
+	c.wLock.Lock()
+	if c.IsClosed() {
+		c.wLock.Unlock()
+		return 0, ConnClosed
+	}
+	if atomic.LoadInt32(&c.writeShut) != writeOpen {
+		c.wLock.Unlock()
+		return 0, ConnWriteClosed
+	}
+
+	//正在连接，连接成功后再发送
+	dialing := atomic.LoadInt32(&c.dialing) == 1
+	var err error
+	if dialing || !c.writeQueue.Empty() {
+		for _, b := range bufs {
+			c.writeQueue.push(b)
+		}
+		//之前还有没发送完的数据，按顺序从写缓冲中发送
+		if !dialing {
+			err = c.eventHandleWrite()
+		}
+	} else {
+		//写缓冲为空时直接发送，只复制没有发送完的部分
+		for _, b := range skipBuffers(bufs, c.writeDirect(bufs)) {
+			c.writeQueue.push(b)
+		}
+	}
+	c.finishWrite(dialing, err)
+
+	return total, nil
+}
+
+// 写入写缓冲之后的处理，需要持有写锁，会释放写锁，之后再调用回调函数
+// err为发送出错需要关闭连接的原因
+func (c *Conn) finishWrite(dialing bool, err error) {
+	if !c.writeQueue.Empty() && atomic.LoadInt64(&c.writeStart) == 0 {
+		//开始计算写超时，并等待可写事件
+		atomic.StoreInt64(&c.writeStart, time.Now().UnixNano())
+		if !dialing {
+			c.rearm()
+		}
+	}
+
+	full := c.checkWriteFull()
+	writable := c.checkWritable()
+	notify := c.takeWriteNotify()
+	c.wLock.Unlock()
+
+	//回调中可能会再次写入，需要在释放写锁之后调用
+	if full {
+		c.onWriteBufferFull()
+	}
+	if writable {
+		c.onWritable()
+	}
+	for _, fn := range notify {
+		fn()
+	}
+
+	//在发送回调之后关闭，关闭回调总是最后一个回调
+	if err != nil {
+		c.closeWithErr(err)
+	} else if c.isShutdown() {
+		//发送完后关闭了写，读也已经关闭
+		c.Close()
+	}
+}
+
+// 获取并清空需要调用的发送回调，需要持有写锁
+func (c *Conn) takeWriteNotify() []func() {
+	fns := c.writeNotify
+	c.writeNotify = nil
+	return fns
+}
+
+// 使用writev直接发送，返回发送的字节数，出错时由之后的可写或者出错事件处理
+func (c *Conn) writeDirect(bufs [][]byte) int {
+	sent := 0
+	for len(bufs) > 0 {
+		n, err := unix.Writev(c.fd, limitBuffers(bufs))
+		if err != nil {
+			if err == unix.EINTR {
+				continue
+			}
+			if err != unix.EAGAIN && err != unix.EWOULDBLOCK {
+				c.logger().Error(context.Background(), "writeDirect error : ", err.Error())
+			}
+			break
+		}
+		if n == 0 {
+			break
+		}
+		sent += n
+		bufs = skipBuffers(bufs, n)
+
+		c.touch()
+	}
+	return sent
+}
 
-	c.eventHandleWrite()
+// 获取写缓冲发送完时关闭的通道，写缓冲为空时返回nil
+func (c *Conn) waitDrain() <-chan struct{} {
+	c.wLock.Lock()
+	defer c.wLock.Unlock()
 
-	return n, err
+	if c.IsClosed() || c.writeQueue.Empty() {
+		return nil
+	}
+	if c.drain == nil {
+		c.drain = make(chan struct{})
+	}
+	return c.drain
 }
 
 // 关闭
 func (c *Conn) Close() error {
-	if atomic.CompareAndSwapInt32(&c.isClose, 0, 1) {
-		//调用关闭回调函数
-		c.server.handler.OnClose(c)
+	c.closeWithErr(nil)
+	return nil
+}
+
+// 获取关闭原因，连接未关闭或者主动关闭时为nil，如ConnPeerClosed、ConnIdleTimeout、unix.ECONNRESET
+func (c *Conn) CloseErr() error {
+	if st := c.closed.Load(); st != nil {
+		return st.err
+	}
+	return nil
+}
+
+// 关闭，并记录关闭原因
+func (c *Conn) closeWithErr(reason error) {
+	st := &closeState{err: reason, reason: closeReasonOf(reason)}
+	if c.closed.CompareAndSwap(nil, st) {
+		atomic.AddInt64(&c.server.closeCounts[st.reason], 1)
+
+		//唤醒等待写缓冲降到低水位的协程
+		close(c.done)
+
+		//取消连接的上下文，从中发起的调用可以尽快结束
+		c.cancel(c.closeCause())
+
+		//从时间轮中删除
+		c.server.wheel.Remove(c)
+
+		//清空写缓冲，通知还没有发送完的文件等，在关闭回调之前调用，关闭回调总是最后一个回调
+		//正在写入的协程获取写锁后会发现连接已关闭
+		abortErr := reason
+		if abortErr == nil {
+			abortErr = ConnClosed
+		}
+		c.wLock.Lock()
+		others := c.release()
+		c.wLock.Unlock()
+		for _, f := range others {
+			f.abort(c, abortErr)
+		}
+
+		//调用关闭回调函数，没有调用过连接回调的不调用
+		if atomic.LoadInt32(&c.connected) == 1 {
+			if h, ok := c.handler.(TcpServerCloseErrHandler); ok {
+				h.OnCloseErr(c, reason)
+			} else {
+				c.handler.OnClose(c)
+			}
+		} else if h, ok := c.handler.(dialCloseHandler); ok && c.outbound {
+			h.onDialClose(c, reason)
+		}
+
+		//唤醒等待TLS握手数据的协程
+		if c.tls != nil {
+			c.tls.Close()
+		}
 
 		//称除事件
 		c.server.reactor.DelHandler(Event{Fd: c.fd})
 
-		//关闭文件描述符
-		unix.Close(c.fd)
+		//删除连接，在关闭fd之前删除，fd被新连接复用时不会删除新连接
+		if c.outbound {
+			c.server.dialManage.DelConn(c)
+		} else {
+			c.server.connManage.DelConn(c)
+
+			//释放准入控制占用的连接数
+			releaseAdmission(c.admission, c.ip)
 
-		//删除连接
-		c.server.connManage.DelConn(c)
+			//通知accept循环有空闲连接位置
+			c.server.notifyConnFree()
+		}
 
-		//归还buf到池中
-		c.readBuf.Reset()
-		c.server.bufPool.Put(c.readBuf)
+		//停止转发，并关闭另一端
+		if r := c.relay.Load(); r != nil {
+			r.stop(abortErr)
+		}
 
-		c.writeBuf.Reset()
-		c.server.bufPool.Put(c.writeBuf)
+		//没有正在进行的操作时关闭fd并归还读缓冲，否则由最后一个操作释放
+		c.decRef()
 	}
-	return nil
 }
 
 // 事件处理
 func (c *Conn) eventHandle(ev *Event) {
+	if !c.incRef() {
+		return
+	}
+	defer c.decRef()
+
+	//零拷贝发送完成的通知在错误队列中，也会触发出错事件，SO_ERROR不为0时才是真的出错
+	//读取通知只需要写锁，在获取事件锁之前处理
+	var zeroCopyErr error
+	if ev.IsError() && c.readZeroCopyNotify() {
+		if zeroCopyErr = c.socketErr(); zeroCopyErr == nil {
+			ev.EventType &^= EventError
+			if !ev.IsRead() && !ev.IsWrite() && !ev.IsClose() {
+				c.rearm()
+				return
+			}
+		}
+	}
+
+	//可写，发送只需要写锁，在获取事件锁之前发送
+	//OnData或者Execute中的Write在WriteFullBlock策略下等待降到低水位时持有事件锁，这里仍然可以发送并唤醒它
+	flushed := false
+	if ev.IsWrite() && !ev.IsError() && !ev.IsClose() && c.relay.Load() == nil {
+		c.flush()
+		flushed = true
+
+		//只有写事件时，读事件不会重新注册
+		if !ev.IsRead() {
+			c.rearm()
+			return
+		}
+	}
+
+	//事件处理中重新注册后，下一个事件可能在其它协程中触发，等待这次处理完成
+	//OnData或者Execute中的Write可能正在等待降到低水位，单次触发的事件已经取消注册，需要重新注册可写事件
+	//等待期间不注册读事件，由等待的协程获取事件锁后读取并重新注册
+	if !c.eventLock.TryLock() {
+		atomic.AddInt32(&c.readWaiting, 1)
+		if atomic.LoadInt64(&c.writeStart) != 0 {
+			c.rearm()
+		}
+		c.eventLock.Lock()
+		atomic.AddInt32(&c.readWaiting, -1)
+	}
+	defer c.eventLock.Unlock()
+
+	if zeroCopyErr != nil {
+		c.eventHandleError(zeroCopyErr)
+		c.closeWithErr(zeroCopyErr)
+		return
+	}
+	//转发模式下由转发处理
+	if r := c.relay.Load(); r != nil {
+		r.eventHandle(c, ev)
+		return
+	}
 	//关闭
 	if ev.IsClose() {
-		c.Close()
+		c.closeWithErr(c.hangupErr())
 		return
 	}
-	//出错
+	//出错，从SO_ERROR获取错误，没有错误时使用ConnSocketError
 	if ev.IsError() {
-		c.eventHandleError()
-		c.Close()
+		err := c.socketErr()
+		if err == nil {
+			err = ConnSocketError
+		}
+		c.eventHandleError(err)
+		c.closeWithErr(err)
 		return
 	}
 	//可读
 	if ev.IsRead() {
 		c.eventHandleRead()
 	}
-	//可写
-	if ev.IsWrite() {
-		c.eventHandleWrite()
+	//可写，获取事件锁之前处于转发模式，转发已经结束，还没有发送
+	if ev.IsWrite() && !flushed {
+		c.flush()
+
+		//只有写事件时，读事件不会重新注册
+		if !ev.IsRead() {
+			c.rearm()
+		}
 	}
 }
 
 // 出错事件处理
-func (c *Conn) eventHandleError() {
-	c.server.handler.OnError(c)
+func (c *Conn) eventHandleError(err error) {
+	if h, ok := c.handler.(TcpServerErrorErrHandler); ok {
+		h.OnErrorErr(c, err)
+		return
+	}
+	c.handler.OnError(c)
+}
+
+// 对端挂断时的关闭原因，SO_ERROR中没有错误时为对端关闭
+func (c *Conn) hangupErr() error {
+	if err := c.socketErr(); err != nil {
+		return err
+	}
+	return ConnPeerClosed
 }
 
 // epoll在ET模式下时，对于读操作，如果read一次没有读尽内核缓冲中的数据，那么下次将得不到读就绪的通知，造成内核缓冲中已有的数据无机会读出，除非有新的数据再次到达。
@@ -159,6 +718,11 @@ func (c *Conn) eventHandleError() {
 // 所以在ET模式下，只要可读，就一直读，直到返回0，或者errno=EAGAIN
 func (c *Conn) eventHandleRead() {
 	for {
+		//暂停了读取，数据留在内核中，恢复时重新注册事件后再读取
+		if atomic.LoadInt32(&c.readPaused) == 1 {
+			c.rearm()
+			return
+		}
 		//阻塞与非阻塞read返回值没有区分，都是 <0表示出错，=0表示连接关闭，>0表示接收到数据大小
 		//非阻塞模式下返回值如果 <0时并且(errno == EINTR || errno == EWOULDBLOCK || errno == EAGAIN)的情况下认为连接是正常的，可以继续接收。
 		n, err := unix.Read(c.fd, c.rbuf)
@@ -168,44 +732,134 @@ func (c *Conn) eventHandleRead() {
 			}
 			// 内核中没有数据可读，为了防止数据丢失，重新注册事件，尝试再次读
 			if err == unix.EAGAIN || err == unix.EWOULDBLOCK {
-				if e := c.server.reactor.ModHandler(Event{
-					Fd:        c.fd,
-					EventType: EventRead | EventError | EventET | EventOneShot,
-				}, c.eventHandle); e != nil {
-					logger.Error(context.Background(), "ModHandler read error : ", err.Error())
-				}
-			}
-			if err != unix.EAGAIN && err != unix.EWOULDBLOCK {
-				logger.Error(context.Background(), "eventHandleRead error : ", err.Error())
+				c.rearm()
+				break
 			}
-			break
+			//对端重置等错误，关闭连接
+			c.logger().Error(context.Background(), "eventHandleRead error : ", err.Error())
+			c.closeWithErr(err)
+			return
 		}
 		if n == 0 {
-			//说明客户端已关闭
-			c.Close()
+			//说明客户端已关闭写
+			c.onReadEOF()
 			return
 		}
 		if n > 0 {
-			//把从fd中读到的数据，写入我们自已的读buf中
-			c.readBuf.Write(c.rbuf[:n])
+			//调用了CloseAfterFlush，丢弃之后收到的数据，等待对端关闭
+			if atomic.LoadInt32(&c.closing) == 1 {
+				continue
+			}
 
-			if c.server.endecoder == nil {
-				//如果没有设置编解码，则直接把buf中的数据全部取出，然后reset
-				c.server.handler.OnData(c, c.readBuf.Bytes())
-				c.readBuf.Reset()
-			} else {
-				//如果设置了编解码，for循环解码，直到IO.EOF
-				for {
-					decode, err := c.server.endecoder.Decode(c.readBuf)
-					if err != nil {
-						if err != io.EOF && err != DataNotEnough {
-							logger.Error(context.Background(), "Decode error : ", err.Error())
-						}
-						break
-					}
-					c.server.handler.OnData(c, decode)
+			c.touch()
+
+			c.onRead(c.rbuf[:n])
+
+			//处理数据时连接可能已经关闭
+			if c.IsClosed() {
+				return
+			}
+			//数据回调中开始了转发，之后的数据由转发处理
+			if r := c.relay.Load(); r != nil {
+				r.eventHandle(c, &Event{Fd: c.fd, EventType: EventRead})
+				return
+			}
+		}
+	}
+}
+
+// 处理从fd中读到的数据，先解析PROXY协议头部，开启了TLS时再解密
+func (c *Conn) onRead(p []byte) {
+	if c.proxy {
+		if p = c.readProxyHeader(p); len(p) == 0 {
+			return
+		}
+	}
+	if c.tls != nil {
+		c.tls.feed(p)
+		return
+	}
+	c.process(p)
+}
+
+// 解析PROXY协议头部，返回头部之后的数据
+func (c *Conn) readProxyHeader(p []byte) []byte {
+	c.proxyBuf = append(c.proxyBuf, p...)
+
+	h, n, err := ParseProxyHeader(c.proxyBuf)
+	if err == DataNotEnough {
+		return nil
+	}
+	if err != nil {
+		c.logger().Warnf(context.Background(), "conn[%s] %s", c.peerAddr, err.Error())
+		c.closeWithErr(err)
+		return nil
+	}
+
+	rest := c.proxyBuf[n:]
+	c.proxyBuf = nil
+	c.proxy = false
+	c.proxyHdr = h
+	if h.Command == ProxyCommandProxy && h.SrcAddr.IsValid() {
+		c.addr = h.SrcAddr.String()
+	}
+
+	c.start()
+
+	return rest
+}
+
+// 处理明文数据，解码后调用数据回调
+func (c *Conn) process(p []byte) {
+	//把从fd中读到的数据，写入我们自已的读buf中
+	c.readBuf.Write(p)
+
+	if c.endecoder == nil {
+		//暂停了读取，数据留在读缓冲中，恢复时再交给回调
+		if atomic.LoadInt32(&c.readPaused) == 1 {
+			return
+		}
+		//如果没有设置编解码，则直接把buf中的数据全部取出，然后清空
+		//先清空再回调，回调中开始转发时不会再次发送这些数据，下次写入之前数据不会被覆盖
+		data := c.readBuf.Bytes()
+		c.readBuf.Clear()
+		if !c.handleHeartbeat(data) {
+			c.onData(data)
+		}
+	} else {
+		//如果设置了编解码，for循环解码，直到IO.EOF
+		decoded := false
+		for {
+			//数据回调中开始了转发，剩余的数据在回调返回后发送到对端
+			if c.relay.Load() != nil {
+				break
+			}
+			//数据回调中暂停了读取，剩余的数据在恢复时解码
+			if atomic.LoadInt32(&c.readPaused) == 1 {
+				break
+			}
+			decode, err := c.endecoder.Decode(c.readBuf)
+			if err != nil {
+				if err != io.EOF && err != DataNotEnough {
+					//剩余的数据无法再解码，关闭连接
+					c.logger().Error(context.Background(), "Decode error : ", err.Error())
+					c.closeWithErr(fmt.Errorf("%w : %w", ConnDecodeFailed, err))
+					return
 				}
+				break
 			}
+			decoded = true
+			if c.handleHeartbeat(decode) {
+				continue
+			}
+			c.onData(decode)
+		}
+
+		//还有未收完的消息，开始计算读超时
+		if c.readBuf.Len() == 0 {
+			atomic.StoreInt64(&c.readStart, 0)
+		} else if decoded || atomic.LoadInt64(&c.readStart) == 0 {
+			atomic.StoreInt64(&c.readStart, time.Now().UnixNano())
 		}
 	}
 }
@@ -213,40 +867,97 @@ func (c *Conn) eventHandleRead() {
 // 对于写操作，如果写缓冲区满了，对于阻塞socket，写操作将阻塞住。对于非阻塞socket，写操作将立即返回-1，同时errno设置为EAGAIN
 // 所以这个时候，在ET模式下，就需要你重新注册事件，尽量把数据写尽。
 // 所以在ET模式下，只要可写，就一直写，直到数据发完，或者errno=EAGAIN
-func (c *Conn) eventHandleWrite() {
+// 返回的错误需要在释放写锁之后关闭连接
+func (c *Conn) eventHandleWrite() error {
 	for {
-		ix, err := c.writeBuf.Read(c.wbuf)
-		if err != nil {
-			if err == io.EOF {
-				//我们自已的数据已经写完了，退出循环
+		if c.writeQueue.Empty() {
+			//我们自已的数据已经写完了，退出循环
+			atomic.StoreInt64(&c.writeStart, 0)
+			if c.drain != nil {
+				close(c.drain)
+				c.drain = nil
+			}
+			//调用了CloseWrite，发送完后关闭写
+			if atomic.LoadInt32(&c.writeShut) == writeShutting {
+				c.shutdownWrite()
+			}
+			break
+		}
+		//队列头部是文件等，使用sendfile等发送
+		if f := c.writeQueue.other(); f != nil {
+			done, err := f.send(c)
+			if err != nil {
+				return err
+			}
+			if !done {
 				break
 			}
+			continue
 		}
+		//使用writev一次发送多个缓冲，发送成功多少再从队列中移除多少，避免部分发送或者EAGAIN时丢失数据
 		//阻塞与非阻塞write返回值没有区分，都是 <0表示出错，=0表示连接关闭，>0表示发送数据大小
 		//非阻塞模式下返回值 <0时并且 (errno == EINTR || errno == EWOULDBLOCK || errno == EAGAIN)的情况下认为连接是正常的，可以继续发送。
-		n, err := unix.Write(c.fd, c.wbuf[:ix])
+		n, err := unix.Writev(c.fd, c.writeQueue.peek())
 		if err != nil {
 			if err == unix.EINTR {
 				continue
 			}
 			// 内核写缓冲区已满，为了防止数据丢失，重新注册事件，尝试再次写
 			if err == unix.EAGAIN || err == unix.EWOULDBLOCK {
-				if e := c.server.reactor.ModHandler(Event{
-					Fd:        c.fd,
-					EventType: EventWrite | EventET | EventOneShot,
-				}, c.eventHandle); e != nil {
-					logger.Error(context.Background(), "ModHandler write error : ", e.Error())
-				}
-			}
-			if err != unix.EAGAIN && err != unix.EWOULDBLOCK {
-				logger.Error(context.Background(), "eventHandleWrite error : ", err.Error())
+				c.rearm()
+				break
 			}
-			break
+			//对端重置等错误，由调用方关闭连接
+			c.logger().Error(context.Background(), "eventHandleWrite error : ", err.Error())
+			return err
 		}
 		if n == 0 {
 			//说明客户端已关闭
-			c.Close()
+			return ConnPeerClosed
+		}
+		c.writeQueue.advance(n)
+
+		c.touch()
+	}
+	return nil
+}
+
+// 重新注册事件，使用ONESHOT时每次事件处理完都需要重新注册
+// 没有关闭读与暂停读取时监听读事件（转发时由转发决定），写缓冲中还有数据时同时监听写事件，避免只注册一种事件导致另一种事件丢失
+// 读取写缓冲状态与修改事件需要一起加锁，否则读回调中的注册可能覆盖写入时增加的写事件
+func (c *Conn) rearm() {
+	if !c.incRef() {
+		return
+	}
+	defer c.decRef()
+
+	c.armLock.Lock()
+	defer c.armLock.Unlock()
+
+	read := atomic.LoadInt32(&c.readShut) == 0 && atomic.LoadInt32(&c.readPaused) == 0
+	write := atomic.LoadInt64(&c.writeStart) != 0
+	if r := c.relay.Load(); r != nil {
+		//转发时根据两个方向的状态监听，都不需要时不再注册，避免对端关闭后一直触发事件
+		var relayWrite bool
+		read, relayWrite = r.interest(c)
+		write = write || relayWrite
+		if !read && !write {
 			return
 		}
 	}
+	//有协程在等待事件锁，由它读取
+	if atomic.LoadInt32(&c.readWaiting) != 0 {
+		read = false
+	}
+	ev := EventError | EventET | EventOneShot
+	if read {
+		ev |= EventRead
+	}
+	if write {
+		ev |= EventWrite
+	}
+	//连接正在关闭时事件可能已经删除
+	if err := c.server.reactor.ModHandler(Event{Fd: c.fd, EventType: ev}, c.eventHandle); err != nil && !c.IsClosed() {
+		c.logger().Error(context.Background(), "ModHandler error : ", err.Error())
+	}
 }
@@ -0,0 +1,124 @@
+package go_epoll
+
+import (
+	"sync/atomic"
+)
+
+// 异步发送完成的回调，err为nil时数据已经全部写入内核
+type AsyncWriteCallback func(err error)
+
+// 写缓冲中的完成标记，之前的数据都发送完时调用回调
+type notifyFrame struct {
+	callback AsyncWriteCallback
+}
+
+// 之前的数据已经发送完，记录需要调用的回调
+func (nf *notifyFrame) send(c *Conn) (bool, error) {
+	c.writeQueue.popOther()
+	c.writeNotify = append(c.writeNotify, func() {
+		nf.callback(nil)
+	})
+	return true, nil
+}
+
+// 连接关闭时还没有发送完
+func (nf *notifyFrame) abort(c *Conn, err error) {
+	nf.callback(err)
+}
+
+// 异步发送数据，复制后放入写缓冲，由可写事件在事件协程中发送，全部写入内核或者出错时调用callback
+// 返回错误时不会调用callback，超过高水位时不会阻塞，WriteFullError策略下返回WriteBufferFull
+// 开启了TLS时在调用方协程中加密，callback可以为nil
+func (c *Conn) AsyncWrite(p []byte, callback AsyncWriteCallback) error {
+	if c.IsClosed() {
+		return ConnClosed
+	}
+	if c.endecoder != nil {
+		encode, err := c.endecoder.Encode(p)
+		if err != nil {
+			return err
+		}
+		p = encode
+	}
+
+	if !c.incRef() {
+		return ConnClosed
+	}
+	defer c.decRef()
+
+	//加密后的数据已经在写缓冲中或者已经发送，只需要在之后放入完成标记，超过高水位也已经写入成功
+	sealed := false
+	if c.tls != nil {
+		if c.IsWriteFull() && WriteFullPolicy(atomic.LoadInt32(&c.writeFullPolicy)) != WriteFullBlock {
+			return WriteBufferFull
+		}
+		if _, err := c.tls.write(p); err != nil {
+			return err
+		}
+		p = nil
+		sealed = true
+	}
+
+	c.wLock.Lock()
+	if c.IsClosed() {
+		c.wLock.Unlock()
+		return ConnClosed
+	}
+	if !sealed && atomic.LoadInt32(&c.writeShut) != writeOpen {
+		c.wLock.Unlock()
+		return ConnWriteClosed
+	}
+	if !sealed && c.writeFull && WriteFullPolicy(atomic.LoadInt32(&c.writeFullPolicy)) != WriteFullBlock {
+		c.wLock.Unlock()
+		return WriteBufferFull
+	}
+	c.writeQueue.push(p)
+	if callback != nil {
+		c.writeQueue.pushOther(&notifyFrame{callback: callback}, 0)
+	}
+	//不在这里发送，注册可写事件后由事件协程发送
+	c.finishWrite(atomic.LoadInt32(&c.dialing) == 1, nil)
+
+	return nil
+}
+
+// 在新的协程中执行fn，与OnData等读事件的处理以及其它Execute按顺序执行，不会同时执行，可以安全地修改OnData中使用的状态
+// OnConnect、Close等在其它协程中触发的OnClose、OnWritable以及发送完成的回调不与fn串行，共享的状态需要自己加锁
+// 不会阻塞，在OnData等回调中调用时，回调返回后才执行，连接关闭之前提交的fn在关闭后仍然会执行
+func (c *Conn) Execute(fn func()) error {
+	if c.IsClosed() {
+		return ConnClosed
+	}
+
+	c.taskLock.Lock()
+	c.tasks = append(c.tasks, fn)
+	start := !c.taskRunning
+	c.taskRunning = true
+	c.taskLock.Unlock()
+
+	if start {
+		go c.runTasks()
+	}
+	return nil
+}
+
+// 持有事件锁执行提交的函数，直到没有新提交的函数
+func (c *Conn) runTasks() {
+	for {
+		c.taskLock.Lock()
+		tasks := c.tasks
+		c.tasks = nil
+		if len(tasks) == 0 {
+			c.taskRunning = false
+			c.taskLock.Unlock()
+			return
+		}
+		c.taskLock.Unlock()
+
+		c.eventLock.Lock()
+		for _, fn := range tasks {
+			fn()
+		}
+		c.eventLock.Unlock()
+	}
+}
@@ -0,0 +1,130 @@
+package go_epoll
+
+import (
+	"bytes"
+	"crypto/tls"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// 回调按放入的顺序在数据发送完后调用
+func TestAsyncWriteOrder(t *testing.T) {
+	done := make(chan []int, 1)
+	h := &testHandler{onConnect: func(c *Conn) {
+		var order []int
+		for i := 0; i < 5; i++ {
+			i := i
+			err := c.AsyncWrite(bytes.Repeat([]byte{byte('a' + i)}, 100<<10), func(err error) {
+				if err != nil {
+					t.Errorf("callback %d: %v", i, err)
+				}
+				order = append(order, i)
+				if len(order) == 5 {
+					done <- order
+				}
+			})
+			if err != nil {
+				t.Errorf("AsyncWrite %d: %v", i, err)
+			}
+		}
+	}}
+	_, addr := newTestServer(t, h)
+	c := dialTestServer(t, addr)
+
+	buf := make([]byte, 5*100<<10)
+	if _, err := io.ReadFull(c, buf); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		if buf[i*100<<10] != byte('a'+i) {
+			t.Fatalf("data out of order at %d", i)
+		}
+	}
+	order := waitChan(t, done)
+	for i, v := range order {
+		if v != i {
+			t.Fatalf("callback order = %v", order)
+		}
+	}
+}
+
+// 开启TLS时，数据加密后超过高水位也是写入成功，需要调用回调，之后的写入返回WriteBufferFull
+func TestAsyncWriteTLSFull(t *testing.T) {
+	const size = 4 << 20
+	type result struct {
+		first, second error
+	}
+	ret := make(chan result, 1)
+	called := make(chan error, 2)
+	h := &testHandler{onData: func(c *Conn, data []byte) {
+		var r result
+		r.first = c.AsyncWrite(make([]byte, size), func(err error) { called <- err })
+		r.second = c.AsyncWrite([]byte("x"), func(err error) { called <- err })
+		ret <- r
+	}}
+	_, addr := newTestServer(t, h, WithTLSConfig(testTLSConfig(t)), WithWriteWatermark(64<<10, 0))
+	c := tls.Client(dialTestServer(t, addr), &tls.Config{InsecureSkipVerify: true})
+	if _, err := c.Write([]byte("go")); err != nil {
+		t.Fatal(err)
+	}
+
+	r := waitChan(t, ret)
+	if r.first != nil {
+		t.Fatalf("first AsyncWrite = %v", r.first)
+	}
+	if r.second != WriteBufferFull {
+		t.Fatalf("second AsyncWrite = %v, want WriteBufferFull", r.second)
+	}
+	if _, err := io.ReadFull(c, make([]byte, size)); err != nil {
+		t.Fatal(err)
+	}
+	if err := waitChan(t, called); err != nil {
+		t.Fatalf("callback = %v", err)
+	}
+	//第二次写入没有发送
+	c.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	if n, _ := c.Read(make([]byte, 1)); n != 0 {
+		t.Fatal("rejected data was sent")
+	}
+	select {
+	case err := <-called:
+		t.Fatalf("rejected callback called: %v", err)
+	default:
+	}
+}
+
+// WriteFullBlock策略下在OnData中写入，等待期间收到新的数据，对端开始读取后可以继续发送
+func TestWriteBlockInOnData(t *testing.T) {
+	const size = 4 << 20
+	done := make(chan error, 2)
+	h := &testHandler{onData: func(c *Conn, data []byte) {
+		var err error
+		for sent := 0; sent < size && err == nil; sent += 64 << 10 {
+			_, err = c.Write(make([]byte, 64<<10))
+		}
+		done <- err
+	}}
+	//减小内核缓冲，保证数据放不下
+	opts := DefaultSockOptions()
+	opts.SendBuf = 32 << 10
+	_, addr := newTestServer(t, h, WithSockOptions(opts), WithWriteWatermark(256<<10, 0), WithWriteFullPolicy(WriteFullBlock))
+	c := dialTestServer(t, addr)
+	c.(*net.TCPConn).SetReadBuffer(32 << 10)
+	c.Write([]byte("a"))
+	time.Sleep(300 * time.Millisecond)
+	//第一次OnData正在等待，读事件在等待事件锁
+	c.Write([]byte("b"))
+	time.Sleep(100 * time.Millisecond)
+
+	c.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := io.CopyN(io.Discard, c, 2*size); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := waitChan(t, done); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package go_epoll
+
+import (
+	"context"
+)
+
+// SetExt使用的属性键
+type extKey struct{}
+
+// 获取连接的上下文，连接关闭时取消，context.Cause返回关闭的错误，本端调用Close时为ConnClosed
+// 在OnData等回调中发起的下游调用可以使用它，连接关闭后调用尽快结束
+func (c *Conn) Context() context.Context {
+	return c.ctx
+}
+
+// 取消上下文的原因
+func (c *Conn) closeCause() error {
+	if st := c.closed.Load(); st != nil && st.err != nil {
+		return st.err
+	}
+	return ConnClosed
+}
+
+// 设置属性，可以在多个协程中调用，key的要求与context.WithValue相同，建议使用自定义的类型避免冲突
+func (c *Conn) SetAttr(key, value any) {
+	c.attrs.Store(key, value)
+}
+
+// 获取属性，没有时返回false
+func (c *Conn) Attr(key any) (any, bool) {
+	return c.attrs.Load(key)
+}
+
+// 删除属性
+func (c *Conn) DelAttr(key any) {
+	c.attrs.Delete(key)
+}
+
+// 属性不存在时设置为value，返回已有的或者新设置的值，loaded表示是否已存在
+func (c *Conn) LoadOrStoreAttr(key, value any) (actual any, loaded bool) {
+	return c.attrs.LoadOrStore(key, value)
+}
+
+// 遍历所有属性，fn返回false时停止，不包括SetExt设置的扩展数据
+func (c *Conn) RangeAttr(fn func(key, value any) bool) {
+	c.attrs.Range(func(key, value any) bool {
+		if _, ok := key.(extKey); ok {
+			return true
+		}
+		return fn(key, value)
+	})
+}
+
+// 获取指定类型的属性，不存在或者类型不匹配时返回零值与false
+func GetAttr[T any](c *Conn, key any) (T, bool) {
+	v, ok := c.attrs.Load(key)
+	if !ok {
+		var zero T
+		return zero, false
+	}
+	t, ok := v.(T)
+	return t, ok
+}
+
+// 获取指定类型的属性，不存在或者类型不匹配时返回def
+func GetAttrOr[T any](c *Conn, key any, def T) T {
+	if t, ok := GetAttr[T](c, key); ok {
+		return t
+	}
+	return def
+}
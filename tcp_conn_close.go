@@ -0,0 +1,143 @@
+package go_epoll
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// 连接关闭的原因，由CloseErr的错误分类得到
+type CloseReason uint8
+
+const (
+	CloseLocal    CloseReason = iota //本端调用了Close或者Abort
+	ClosePeer                        //对端关闭了连接
+	CloseReset                       //对端重置了连接，如ECONNRESET、EPIPE
+	CloseError                       //socket出错，如SO_ERROR中的错误、读写出错、连接失败
+	CloseTimeout                     //超时，如空闲、读、写、心跳、连接超时
+	CloseDecode                      //解码失败
+	CloseProtocol                    //PROXY协议头部无效或者TLS出错
+	CloseShutdown                    //服务器关闭
+	closeReasonCount
+)
+
+func (r CloseReason) String() string {
+	switch r {
+	case CloseLocal:
+		return "local"
+	case ClosePeer:
+		return "peer"
+	case CloseReset:
+		return "reset"
+	case CloseError:
+		return "error"
+	case CloseTimeout:
+		return "timeout"
+	case CloseDecode:
+		return "decode"
+	case CloseProtocol:
+		return "protocol"
+	case CloseShutdown:
+		return "shutdown"
+	}
+	return ""
+}
+
+// 关闭的错误与分类后的原因，关闭时一次性发布，读取时不需要加锁
+type closeState struct {
+	err    error
+	reason CloseReason
+}
+
+// 根据关闭的错误获取关闭原因
+func closeReasonOf(err error) CloseReason {
+	switch {
+	case err == nil || errors.Is(err, ConnClosed) || errors.Is(err, ConnAborted):
+		return CloseLocal
+	case errors.Is(err, ConnPeerClosed):
+		return ClosePeer
+	case errors.Is(err, ServerClosed):
+		return CloseShutdown
+	case errors.Is(err, ConnDecodeFailed):
+		return CloseDecode
+	case errors.Is(err, ProxyHeaderInvalid) || errors.Is(err, TLSFailed):
+		return CloseProtocol
+	case errors.Is(err, ConnIdleTimeout) || errors.Is(err, ConnReadTimeout) || errors.Is(err, ConnWriteTimeout) ||
+		errors.Is(err, ConnWriteFullTimeout) || errors.Is(err, ConnHeartbeatTimeout) || errors.Is(err, ConnDialTimeout) ||
+		errors.Is(err, ConnFlushTimeout) || errors.Is(err, unix.ETIMEDOUT):
+		return CloseTimeout
+	case errors.Is(err, unix.ECONNRESET) || errors.Is(err, unix.EPIPE) || errors.Is(err, unix.ECONNABORTED):
+		return CloseReset
+	}
+	return CloseError
+}
+
+// 停止读取，之后收到的数据直接丢弃，发送完写缓冲中的数据后关闭写，对端也关闭后关闭连接
+// 等待对端关闭可以避免关闭时还有未读的数据导致发送RST，对端丢失最后发送的数据
+// 超过timeout还没有关闭时直接关闭，数据还没有发送完时CloseErr为ConnFlushTimeout，已经发送完时CloseErr为nil
+// timeout不大于0时使用配置的FlushTimeout，之后不能再写入
+func (c *Conn) CloseAfterFlush(timeout time.Duration) error {
+	if c.IsClosed() {
+		return ConnClosed
+	}
+	if !atomic.CompareAndSwapInt32(&c.closing, 0, 1) {
+		return nil
+	}
+	//对端一直不关闭时也不能无限等待
+	if timeout <= 0 {
+		timeout = c.server.cfg.FlushTimeout
+	}
+	atomic.StoreInt64(&c.flushClose, time.Now().Add(timeout).UnixNano())
+	c.scheduleTimeout()
+
+	//对端已经关闭写或者调用了CloseRead时，发送完后直接关闭连接
+	return c.CloseWrite()
+}
+
+// 立即关闭，丢弃写缓冲中的数据，设置SO_LINGER为0，关闭时发送RST，CloseErr为ConnAborted
+func (c *Conn) Abort() error {
+	if c.IsClosed() {
+		return ConnClosed
+	}
+	c.closeWithErr(ConnAborted)
+	return nil
+}
+
+// 获取关闭原因，连接未关闭时为CloseLocal
+func (c *Conn) CloseReason() CloseReason {
+	if st := c.closed.Load(); st != nil {
+		return st.reason
+	}
+	return CloseLocal
+}
+
+// 获取socket上的错误，SO_ERROR为0时返回nil
+func (c *Conn) socketErr() error {
+	soErr, err := unix.GetsockoptInt(c.fd, unix.SOL_SOCKET, unix.SO_ERROR)
+	if err != nil {
+		return err
+	}
+	if soErr == 0 {
+		return nil
+	}
+	return unix.Errno(soErr)
+}
+
+// 获取各种原因关闭的连接数量，包括通过Dialer发起的连接
+func (s *TcpServer) CloseCount(reason CloseReason) int64 {
+	if reason >= closeReasonCount {
+		return 0
+	}
+	return atomic.LoadInt64(&s.closeCounts[reason])
+}
+
+// 获取所有原因关闭的连接数量
+func (s *TcpServer) CloseCounts() map[CloseReason]int64 {
+	counts := make(map[CloseReason]int64, closeReasonCount)
+	for r := CloseReason(0); r < closeReasonCount; r++ {
+		counts[r] = atomic.LoadInt64(&s.closeCounts[r])
+	}
+	return counts
+}
@@ -0,0 +1,154 @@
+package go_epoll
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestCloseReasonOf(t *testing.T) {
+	tests := []struct {
+		err    error
+		reason CloseReason
+	}{
+		{nil, CloseLocal},
+		{ConnClosed, CloseLocal},
+		{ConnAborted, CloseLocal},
+		{ConnPeerClosed, ClosePeer},
+		{ServerClosed, CloseShutdown},
+		{ConnDecodeFailed, CloseDecode},
+		{fmt.Errorf("%w : bad length", ConnDecodeFailed), CloseDecode},
+		{ProxyHeaderInvalid, CloseProtocol},
+		{TLSFailed, CloseProtocol},
+		{ConnIdleTimeout, CloseTimeout},
+		{ConnDialTimeout, CloseTimeout},
+		{ConnFlushTimeout, CloseTimeout},
+		{unix.ETIMEDOUT, CloseTimeout},
+		{unix.ECONNRESET, CloseReset},
+		{unix.EPIPE, CloseReset},
+		{unix.ECONNREFUSED, CloseError},
+		{errors.New("other"), CloseError},
+	}
+	for _, tt := range tests {
+		if r := closeReasonOf(tt.err); r != tt.reason {
+			t.Errorf("closeReasonOf(%v) = %v, want %v", tt.err, r, tt.reason)
+		}
+	}
+	for r := CloseReason(0); r < closeReasonCount; r++ {
+		if r.String() == "" {
+			t.Errorf("CloseReason(%d) has no name", r)
+		}
+	}
+}
+
+// 按原因统计关闭的连接，Abort时对端收到RST
+func TestCloseCounts(t *testing.T) {
+	tests := []struct {
+		name   string
+		close  func(c *Conn)
+		peer   bool //由对端关闭
+		reason CloseReason
+	}{
+		{"local", func(c *Conn) { c.Close() }, false, CloseLocal},
+		{"abort", func(c *Conn) { c.Abort() }, false, CloseLocal},
+		{"peer", nil, true, ClosePeer},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			closed := make(chan CloseReason, 1)
+			h := &testHandler{
+				onData: func(c *Conn, data []byte) {
+					if tt.close != nil {
+						tt.close(c)
+					}
+				},
+				onClose: func(c *Conn, err error) { closed <- c.CloseReason() },
+			}
+			s, addr := newTestServer(t, h)
+			c := dialTestServer(t, addr)
+			c.Write([]byte("go"))
+			if tt.peer {
+				c.Close()
+			}
+			if r := waitChan(t, closed); r != tt.reason {
+				t.Fatalf("CloseReason = %v, want %v", r, tt.reason)
+			}
+			if n := s.CloseCount(tt.reason); n != 1 {
+				t.Fatalf("CloseCount(%v) = %d", tt.reason, n)
+			}
+			if s.CloseCounts()[tt.reason] != 1 || s.CloseCount(closeReasonCount) != 0 {
+				t.Fatalf("CloseCounts = %v", s.CloseCounts())
+			}
+			if tt.peer {
+				return
+			}
+			_, err := c.Read(make([]byte, 1))
+			if tt.name == "abort" && !errors.Is(err, unix.ECONNRESET) {
+				t.Fatalf("client read after Abort = %v, want ECONNRESET", err)
+			}
+			if tt.name == "local" && err != io.EOF {
+				t.Fatalf("client read after Close = %v, want EOF", err)
+			}
+		})
+	}
+}
+
+// 发送完后关闭，对端关闭、对端不关闭、对端不读取时的关闭原因
+func TestCloseAfterFlush(t *testing.T) {
+	const size = 4 << 20
+	tests := []struct {
+		name      string
+		read      bool //对端是否读取数据
+		peerClose bool //对端读完后是否关闭
+		err       error
+		reason    CloseReason
+	}{
+		{"peer closes", true, true, nil, CloseLocal},
+		{"peer keeps open", true, false, nil, CloseLocal},
+		{"peer not reading", false, false, ConnFlushTimeout, CloseTimeout},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			type result struct {
+				err    error
+				reason CloseReason
+			}
+			closed := make(chan result, 1)
+			h := &testHandler{
+				onData: func(c *Conn, data []byte) {
+					c.Write(make([]byte, size))
+					c.CloseAfterFlush(500 * time.Millisecond)
+				},
+				onClose: func(c *Conn, err error) {
+					closed <- result{err, c.CloseReason()}
+				},
+			}
+			//减小内核缓冲，对端不读取时数据发送不完
+			opts := DefaultSockOptions()
+			opts.SendBuf = 32 << 10
+			_, addr := newTestServer(t, h, WithSockOptions(opts))
+			c := dialTestServer(t, addr)
+			c.(*net.TCPConn).SetReadBuffer(32 << 10)
+			c.Write([]byte("go"))
+
+			if tt.read {
+				c.SetReadDeadline(time.Now().Add(5 * time.Second))
+				if n, err := io.Copy(io.Discard, c); err != nil || n != size {
+					t.Fatalf("read = %d %v", n, err)
+				}
+				if tt.peerClose {
+					c.Close()
+				}
+			}
+			r := waitChan(t, closed)
+			if r.err != tt.err || r.reason != tt.reason {
+				t.Fatalf("close = %v %v, want %v %v", r.err, r.reason, tt.err, tt.reason)
+			}
+		})
+	}
+}
@@ -0,0 +1,133 @@
+package go_epoll
+
+import (
+	"context"
+	"sync/atomic"
+
+	"golang.org/x/sys/unix"
+)
+
+// 可选接口，实现后对端关闭写时调用OnHalfClose，连接不会关闭，可以继续发送，发送完后调用CloseWrite或者Close
+// 没有实现时对端关闭写就关闭连接
+type TcpServerHalfCloseHandler interface {
+	OnHalfClose(conn *Conn)
+}
+
+// 写关闭的状态
+const (
+	writeOpen     int32 = iota //可以写
+	writeShutting              //等待写缓冲发送完后关闭
+	writeShut                  //已经关闭
+)
+
+// 发送完写缓冲中的数据后关闭写，对端读到EOF，之后不能再写入
+// 读也已经关闭时，发送完后关闭连接
+func (c *Conn) CloseWrite() error {
+	if !c.incRef() {
+		return ConnClosed
+	}
+	defer c.decRef()
+
+	//开启了TLS时先发送close_notify
+	if c.tls != nil {
+		c.tls.closeWrite()
+	}
+
+	c.wLock.Lock()
+	if c.IsClosed() || !atomic.CompareAndSwapInt32(&c.writeShut, writeOpen, writeShutting) {
+		c.wLock.Unlock()
+		return nil
+	}
+	if c.writeQueue.Empty() && atomic.LoadInt32(&c.dialing) == 0 {
+		c.shutdownWrite()
+	}
+	c.wLock.Unlock()
+
+	if c.isShutdown() {
+		c.Close()
+	}
+	return nil
+}
+
+// 关闭读，之后不再接收数据，也不再调用OnData，写也已经关闭时关闭连接
+func (c *Conn) CloseRead() error {
+	if !c.incRef() {
+		return ConnClosed
+	}
+	defer c.decRef()
+	if !atomic.CompareAndSwapInt32(&c.readShut, 0, 1) {
+		return nil
+	}
+	if err := unix.Shutdown(c.fd, unix.SHUT_RD); err != nil && err != unix.ENOTCONN {
+		c.logger().Error(context.Background(), "shutdown read error : ", err.Error())
+	}
+
+	if c.isShutdown() {
+		c.Close()
+		return nil
+	}
+	c.rearm()
+	return nil
+}
+
+// 读是否已经关闭，本端调用了CloseRead或者对端关闭了写
+func (c *Conn) IsReadClosed() bool {
+	return atomic.LoadInt32(&c.readShut) == 1
+}
+
+// 写是否已经关闭，调用CloseWrite之后为true，写缓冲中可能还有数据
+func (c *Conn) IsWriteClosed() bool {
+	return atomic.LoadInt32(&c.writeShut) != writeOpen
+}
+
+// 对端关闭了写，实现了OnHalfClose时继续发送，否则关闭连接
+func (c *Conn) onReadEOF() {
+	//本端已经调用了CloseRead
+	if atomic.LoadInt32(&c.readShut) == 1 {
+		return
+	}
+	//调用了CloseAfterFlush，发送完后关闭
+	if atomic.LoadInt32(&c.closing) == 1 {
+		if !atomic.CompareAndSwapInt32(&c.readShut, 0, 1) {
+			return
+		}
+		if c.isShutdown() {
+			c.Close()
+			return
+		}
+		c.rearm()
+		return
+	}
+	h, ok := c.handler.(TcpServerHalfCloseHandler)
+	//TLS需要close_notify，握手完成之前也无法继续
+	if !ok || c.tls != nil || atomic.LoadInt32(&c.connected) == 0 {
+		c.closeWithErr(ConnPeerClosed)
+		return
+	}
+	if !atomic.CompareAndSwapInt32(&c.readShut, 0, 1) {
+		return
+	}
+
+	h.OnHalfClose(c)
+
+	if c.isShutdown() {
+		c.Close()
+		return
+	}
+	c.rearm()
+}
+
+// 写缓冲发送完后关闭写，需要持有写锁
+func (c *Conn) shutdownWrite() {
+	if !atomic.CompareAndSwapInt32(&c.writeShut, writeShutting, writeShut) {
+		return
+	}
+	if err := unix.Shutdown(c.fd, unix.SHUT_WR); err != nil && err != unix.ENOTCONN {
+		c.logger().Error(context.Background(), "shutdown write error : ", err.Error())
+	}
+}
+
+// 读写是否都已经关闭
+func (c *Conn) isShutdown() bool {
+	return atomic.LoadInt32(&c.readShut) == 1 && atomic.LoadInt32(&c.writeShut) == writeShut
+}
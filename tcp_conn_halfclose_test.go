@@ -0,0 +1,113 @@
+package go_epoll
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// 实现了OnHalfClose的测试回调
+type testHalfCloseHandler struct {
+	testHandler
+	onHalfClose func(c *Conn)
+}
+
+func (h *testHalfCloseHandler) OnHalfClose(c *Conn) {
+	h.onHalfClose(c)
+}
+
+func TestHalfClose(t *testing.T) {
+	tests := []struct {
+		name       string
+		halfClose  bool //是否实现OnHalfClose
+		closeFirst bool //服务器在OnData中先关闭写
+		reply      string
+		err        error
+	}{
+		{"reply after peer close", true, false, "pong", nil},
+		{"no handler", false, false, "", ConnPeerClosed},
+		{"server closes write first", true, true, "pong", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			closed := make(chan error, 1)
+			halfClosed := make(chan struct{}, 1)
+			th := testHandler{
+				onData: func(c *Conn, data []byte) {
+					if tt.closeFirst && !c.IsWriteClosed() {
+						c.Write([]byte("pong"))
+						c.CloseWrite()
+					}
+				},
+				onClose: func(c *Conn, err error) { closed <- err },
+			}
+			var h TcpServerHandler = &th
+			if tt.halfClose {
+				h = &testHalfCloseHandler{testHandler: th, onHalfClose: func(c *Conn) {
+					if !c.IsReadClosed() {
+						t.Error("read not closed in OnHalfClose")
+					}
+					halfClosed <- struct{}{}
+					if !c.IsWriteClosed() {
+						c.Write([]byte("pong"))
+						c.CloseWrite()
+					}
+				}}
+			}
+			_, addr := newTestServer(t, h)
+			c := dialTestServer(t, addr).(*net.TCPConn)
+			if tt.closeFirst {
+				c.Write([]byte("ping"))
+				//服务器关闭写后还可以继续发送
+				got, err := io.ReadAll(c)
+				if err != nil || string(got) != tt.reply {
+					t.Fatalf("read = %q %v", got, err)
+				}
+				if _, err = c.Write([]byte("more")); err != nil {
+					t.Fatal(err)
+				}
+				c.CloseWrite()
+			} else {
+				c.Write([]byte("ping"))
+				c.CloseWrite()
+				got, err := io.ReadAll(c)
+				if err != nil || string(got) != tt.reply {
+					t.Fatalf("read = %q %v", got, err)
+				}
+			}
+			if tt.halfClose {
+				waitChan(t, halfClosed)
+			}
+			if err := waitChan(t, closed); err != tt.err {
+				t.Fatalf("close err = %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
+
+// 关闭读后不再调用OnData，写还可以继续
+func TestCloseRead(t *testing.T) {
+	data := make(chan string, 2)
+	h := &testHandler{onData: func(c *Conn, d []byte) {
+		data <- string(d)
+		c.CloseRead()
+		c.Write([]byte("ok"))
+	}}
+	_, addr := newTestServer(t, h)
+	c := dialTestServer(t, addr)
+	c.Write([]byte("first"))
+	if got := waitChan(t, data); got != "first" {
+		t.Fatalf("OnData = %q", got)
+	}
+	buf := make([]byte, 2)
+	if _, err := io.ReadFull(c, buf); err != nil || string(buf) != "ok" {
+		t.Fatalf("read = %q %v", buf, err)
+	}
+	c.Write([]byte("second"))
+	select {
+	case got := <-data:
+		t.Fatalf("OnData after CloseRead = %q", got)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -5,13 +5,15 @@ import (
 )
 
 type ConnManage struct {
-	conns     map[int]*Conn //所有连接
-	connsLock sync.RWMutex  //连接锁
+	conns     map[int]*Conn    //所有连接
+	ids       map[uint64]*Conn //所有连接，以连接ID为键
+	connsLock sync.RWMutex     //连接锁
 }
 
 func NewConnManage() *ConnManage {
 	return &ConnManage{
 		conns:     make(map[int]*Conn),
+		ids:       make(map[uint64]*Conn),
 		connsLock: sync.RWMutex{},
 	}
 }
@@ -20,12 +22,17 @@ func (cm *ConnManage) AddConn(conn *Conn) {
 	cm.connsLock.Lock()
 	defer cm.connsLock.Unlock()
 	cm.conns[conn.fd] = conn
+	cm.ids[conn.id] = conn
 }
 
 func (cm *ConnManage) DelConn(conn *Conn) {
 	cm.connsLock.Lock()
 	defer cm.connsLock.Unlock()
-	delete(cm.conns, conn.fd)
+	//fd已经被新连接复用时不删除新连接
+	if cm.conns[conn.fd] == conn {
+		delete(cm.conns, conn.fd)
+	}
+	delete(cm.ids, conn.id)
 }
 
 func (cm *ConnManage) GetConn(fd int) (*Conn, bool) {
@@ -35,8 +42,31 @@ func (cm *ConnManage) GetConn(fd int) (*Conn, bool) {
 	return conn, ok
 }
 
+// 根据连接ID获取连接，连接关闭后返回false
+func (cm *ConnManage) GetConnByID(id uint64) (*Conn, bool) {
+	cm.connsLock.RLock()
+	defer cm.connsLock.RUnlock()
+	conn, ok := cm.ids[id]
+	return conn, ok
+}
+
+// 连接数量
+func (cm *ConnManage) Len() int {
+	cm.connsLock.RLock()
+	defer cm.connsLock.RUnlock()
+	return len(cm.ids)
+}
+
 func (cm *ConnManage) Close() {
-	for _, c := range cm.conns {
-		c.Close()
+	//关闭时会删除连接，先复制再关闭
+	cm.connsLock.RLock()
+	conns := make([]*Conn, 0, len(cm.ids))
+	for _, c := range cm.ids {
+		conns = append(conns, c)
+	}
+	cm.connsLock.RUnlock()
+
+	for _, c := range conns {
+		c.closeWithErr(ServerClosed)
 	}
 }
@@ -0,0 +1,56 @@
+package go_epoll
+
+import "sync/atomic"
+
+// 暂停读取，不再调用OnData，数据留在内核中，由TCP流量控制反压对端
+// 在OnData中调用时，读缓冲中还没有解码的数据在恢复后再解码，转发时无效
+func (c *Conn) PauseRead() error {
+	if c.IsClosed() {
+		return ConnClosed
+	}
+	if !atomic.CompareAndSwapInt32(&c.readPaused, 0, 1) {
+		return nil
+	}
+	c.rearm()
+	return nil
+}
+
+// 恢复读取，先处理读缓冲中已经收到的数据，开启TLS时再解密已经收到的记录，最后读取内核中的数据
+func (c *Conn) ResumeRead() error {
+	if c.IsClosed() {
+		return ConnClosed
+	}
+	if !atomic.CompareAndSwapInt32(&c.readPaused, 1, 0) {
+		return nil
+	}
+	//可能在OnData中调用，正在处理事件的协程持有事件锁，在新的协程中等待
+	go c.resumeRead()
+	return nil
+}
+
+// 处理读缓冲中的数据，然后像可读事件一样读取直到EAGAIN并重新注册事件
+func (c *Conn) resumeRead() {
+	if !c.incRef() {
+		return
+	}
+	defer c.decRef()
+
+	c.eventLock.Lock()
+	defer c.eventLock.Unlock()
+
+	if c.readBuf.Len() > 0 && atomic.LoadInt32(&c.readPaused) == 0 {
+		c.process(nil)
+	}
+	//TLS连接中可能还有已经收到但没有解密的记录
+	if c.tls != nil && !c.IsClosed() {
+		c.tls.resume()
+	}
+	if !c.IsClosed() && c.relay.Load() == nil {
+		c.eventHandleRead()
+	}
+}
+
+// 是否暂停了读取
+func (c *Conn) IsReadPaused() bool {
+	return atomic.LoadInt32(&c.readPaused) == 1
+}
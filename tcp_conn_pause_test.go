@@ -0,0 +1,74 @@
+package go_epoll
+
+import (
+	"crypto/tls"
+	"net"
+	"testing"
+	"time"
+)
+
+// 暂停后不再调用OnData，读缓冲中没有解码的数据与之后收到的数据在恢复后按顺序处理
+func TestPauseResumeRead(t *testing.T) {
+	for _, useTLS := range []bool{false, true} {
+		name := "plain"
+		if useTLS {
+			name = "tls"
+		}
+		t.Run(name, func(t *testing.T) {
+			paused := make(chan *Conn, 1)
+			msgs := make(chan string, 8)
+			h := &testHandler{onData: func(c *Conn, data []byte) {
+				msgs <- string(data)
+				if string(data) == "a\n" {
+					c.PauseRead()
+					if !c.IsReadPaused() {
+						t.Error("not paused")
+					}
+					paused <- c
+				}
+			}}
+			var opts []ServerOption
+			if useTLS {
+				opts = append(opts, WithTLSConfig(testTLSConfig(t)))
+			}
+			s, err := NewTcpServer("127.0.0.1:0", opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			s.SetHandler(h)
+			s.SetEnDecoder(testLineCodec{})
+			var c net.Conn = dialTestServer(t, serveTestServer(t, s))
+			if useTLS {
+				c = tls.Client(c, &tls.Config{InsecureSkipVerify: true})
+			}
+			c.Write([]byte("a\nb\n"))
+
+			sc := waitChan(t, paused)
+			c.Write([]byte("c\n"))
+			if got := waitChan(t, msgs); got != "a\n" {
+				t.Fatalf("OnData = %q", got)
+			}
+			select {
+			case got := <-msgs:
+				t.Fatalf("OnData while paused = %q", got)
+			case <-time.After(100 * time.Millisecond):
+			}
+
+			if err := sc.ResumeRead(); err != nil {
+				t.Fatal(err)
+			}
+			for _, want := range []string{"b\n", "c\n"} {
+				if got := waitChan(t, msgs); got != want {
+					t.Fatalf("OnData after resume = %q, want %q", got, want)
+				}
+			}
+			if sc.IsReadPaused() {
+				t.Fatal("still paused")
+			}
+			c.Write([]byte("d\n"))
+			if got := waitChan(t, msgs); got != "d\n" {
+				t.Fatalf("OnData = %q", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,99 @@
+package go_epoll
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"golang.org/x/sys/unix"
+)
+
+// 增加引用计数，连接已关闭时返回false，返回true时需要调用decRef
+// 使用fd与读缓冲的操作都需要持有引用，关闭后等所有操作结束才关闭fd并归还读缓冲，避免fd被新连接复用或者缓冲被其它连接使用
+func (c *Conn) incRef() bool {
+	atomic.AddInt32(&c.refs, 1)
+	if c.IsClosed() {
+		c.decRef()
+		return false
+	}
+	return true
+}
+
+// 减少引用计数，连接已关闭并且没有操作时释放资源
+func (c *Conn) decRef() {
+	if atomic.AddInt32(&c.refs, -1) == 0 && atomic.CompareAndSwapInt32(&c.freed, 0, 1) {
+		c.free()
+	}
+}
+
+// 关闭fd并归还读缓冲，只执行一次
+func (c *Conn) free() {
+	//调用了Abort，关闭时发送RST
+	if st := c.closed.Load(); st != nil && st.err == ConnAborted {
+		unix.SetsockoptLinger(c.fd, unix.SOL_SOCKET, unix.SO_LINGER, &unix.Linger{Onoff: 1, Linger: 0})
+	}
+
+	//关闭文件描述符
+	unix.Close(c.fd)
+
+	//归还读缓冲到池中
+	c.readBuf.Clear()
+	c.server.bufPool.Put(c.readBuf)
+	c.readBuf = nil
+}
+
+// 连接还没有开始使用就失败时释放，如发起连接失败，与关闭后一样关闭fd并归还读缓冲
+func (c *Conn) discard() {
+	if atomic.CompareAndSwapInt32(&c.freed, 0, 1) {
+		c.free()
+	}
+}
+
+// 在连接没有关闭时对fd执行操作
+func (c *Conn) control(fn func(fd int) error) error {
+	if !c.incRef() {
+		return ConnClosed
+	}
+	defer c.decRef()
+	return fn(c.fd)
+}
+
+// Retain取得的缓冲，Release可以多次调用，只会归还一次
+type Retained struct {
+	buf      *Buffer    //data所在的缓冲
+	pool     *sync.Pool //归还到的缓冲池
+	released int32      //1表示已经归还
+}
+
+// 归还缓冲到池中，之后不能再使用OnData中的数据，可以为nil
+func (r *Retained) Release() {
+	if r == nil || !atomic.CompareAndSwapInt32(&r.released, 0, 1) {
+		return
+	}
+	r.buf.Clear()
+	r.pool.Put(r.buf)
+	r.buf = nil
+}
+
+// 在OnData中调用，获取data所在缓冲的所有权，返回后data不会被覆盖，使用完后调用Release归还到池中
+// 不在OnData中调用时返回nil，同一次OnData中多次调用返回同一个Retained
+func (c *Conn) Retain() *Retained {
+	if atomic.LoadInt32(&c.inOnData) == 0 {
+		return nil
+	}
+	if c.retained == nil {
+		//还没有解码的数据复制到新的缓冲中，之后的数据写入新的缓冲
+		b := c.server.bufPool.Get().(*Buffer)
+		b.Write(c.readBuf.Bytes())
+		c.retained = &Retained{buf: c.readBuf, pool: c.server.bufPool}
+		c.readBuf = b
+	}
+	return c.retained
+}
+
+// 调用数据回调，data只在回调中有效，需要保存时复制或者调用Retain
+func (c *Conn) onData(data []byte) {
+	atomic.StoreInt32(&c.inOnData, 1)
+	c.handler.OnData(c, data)
+	atomic.StoreInt32(&c.inOnData, 0)
+	c.retained = nil
+}
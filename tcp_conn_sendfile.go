@@ -0,0 +1,290 @@
+package go_epoll
+
+import (
+	"context"
+	"io"
+	"os"
+	"runtime"
+	"sync/atomic"
+
+	"golang.org/x/sys/unix"
+)
+
+// 一次sendfile最多发送的字节数，与writev一样，太大时内核发送缓冲较小的连接会变慢
+const maxSendfileSize = maxWritevSize
+
+// 发送文件的进度回调，每次发送了一部分之后调用，sent等于length或者err不为nil时表示发送结束
+type SendFileCallback func(conn *Conn, sent, length int64, err error)
+
+// 写缓冲中待发送的文件
+type fileFrame struct {
+	file     *os.File         //保持对文件的引用，发送结束之前不会被回收，回收时会关闭fd
+	fd       int              //文件描述符
+	offset   int64            //下一次发送的位置
+	length   int64            //需要发送的字节数
+	sent     int64            //已经发送的字节数
+	callback SendFileCallback //进度回调
+	buf      []byte           //不支持sendfile时使用的读缓冲
+	notified int64            //上次回调时已经发送的字节数
+}
+
+// 还没有发送的字节数，不超过max
+func (ff *fileFrame) remain(max int64) int64 {
+	size := ff.length - ff.sent
+	if size > max {
+		size = max
+	}
+	return size
+}
+
+// 发送文件中从offset开始的length个字节，length<=0时发送到文件末尾
+// 与Write按顺序发送，内核直接从文件复制到socket，不经过用户空间，文件不支持sendfile时使用pread与write
+// 发送结束之前不能关闭f，设置的编码不会作用于文件内容，callback可以为nil
+// 文件的长度计入Buffered与写缓冲的水位，开启了TLS时无法使用sendfile，每次可写时读取一块并加密发送
+func (c *Conn) SendFile(f *os.File, offset, length int64, callback SendFileCallback) error {
+	if c.IsClosed() {
+		return ConnClosed
+	}
+	if length <= 0 {
+		fi, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		length = fi.Size() - offset
+	}
+	if length <= 0 {
+		if callback != nil {
+			callback(c, 0, 0, nil)
+		}
+		return nil
+	}
+
+	ff := &fileFrame{
+		file:     f,
+		fd:       int(f.Fd()),
+		offset:   offset,
+		length:   length,
+		callback: callback,
+	}
+
+	if c.tls != nil {
+		return c.sendFileTLS(ff)
+	}
+
+	if !c.incRef() {
+		return ConnClosed
+	}
+	defer c.decRef()
+
+	c.wLock.Lock()
+	if c.IsClosed() {
+		c.wLock.Unlock()
+		return ConnClosed
+	}
+	if atomic.LoadInt32(&c.writeShut) != writeOpen {
+		c.wLock.Unlock()
+		return ConnWriteClosed
+	}
+	dialing := atomic.LoadInt32(&c.dialing) == 1
+	c.writeQueue.pushOther(ff, int(ff.length))
+	var err error
+	if !dialing {
+		err = c.eventHandleWrite()
+	}
+	c.finishWrite(dialing, err)
+
+	return nil
+}
+
+// 开启了TLS时，文件放入写缓冲，发送时读取并加密，文件的长度计入待发送的字节数
+func (c *Conn) sendFileTLS(ff *fileFrame) error {
+	//握手完成后才能加密，在持有写锁发送时不能等待握手
+	if err := c.tls.conn.Handshake(); err != nil {
+		return err
+	}
+
+	c.tls.encLock.Lock()
+	full, err := c.pushTLSFrame(&tlsFileFrame{ff: ff}, int(ff.length))
+	c.tls.encLock.Unlock()
+
+	if full {
+		c.onWriteBufferFull()
+	}
+	return err
+}
+
+// 开启TLS时写缓冲中待发送的文件，每次可写时读取一块并加密，内存中最多只有一块
+type tlsFileFrame struct {
+	ff    *fileFrame //发送进度
+	buf   []byte     //读取文件的缓冲
+	out   []byte     //已经加密还没有发送的密文
+	chunk int        //out对应的文件字节数
+}
+
+// 发送上次没有发送完的密文，再读取并加密一块发送，发送完这一块后等待下次可写事件，不会长时间占用事件协程
+func (f *tlsFileFrame) send(c *Conn) (bool, error) {
+	ff := f.ff
+	sealed := false
+	for {
+		if len(f.out) > 0 {
+			out, err := c.writeSealed(f.out)
+			f.out = out
+			if err == unix.EAGAIN || err == unix.EWOULDBLOCK {
+				c.queueFileNotify(ff, nil)
+				c.rearm()
+				return false, nil
+			}
+			if err != nil {
+				return false, f.fail(c, err)
+			}
+			ff.sent += int64(f.chunk)
+			c.writeQueue.consume(f.chunk)
+			f.chunk = 0
+		}
+		if ff.sent >= ff.length {
+			c.popTLSFrame()
+			c.queueFileNotify(ff, nil)
+			runtime.KeepAlive(ff.file)
+			return true, nil
+		}
+		if sealed {
+			c.queueFileNotify(ff, nil)
+			c.rearm()
+			return false, nil
+		}
+
+		if f.buf == nil {
+			f.buf = make([]byte, maxSendfileSize)
+		}
+		n, err := preadFull(ff.fd, f.buf[:ff.remain(maxSendfileSize)], ff.offset)
+		if err != nil {
+			return false, f.fail(c, err)
+		}
+		out, ok, err := c.tls.seal(func() error {
+			_, err := c.tls.conn.Write(f.buf[:n])
+			return err
+		})
+		if !ok {
+			//其它协程正在把数据放入写缓冲，稍后重试
+			c.rearm()
+			return false, nil
+		}
+		if err != nil {
+			return false, f.fail(c, err)
+		}
+		ff.offset += int64(n)
+		f.out, f.chunk, sealed = out, n, true
+	}
+}
+
+// 发送出错，文件已经发送了一部分，之后的数据无法再按顺序发送，返回的错误需要关闭连接
+func (f *tlsFileFrame) fail(c *Conn, err error) error {
+	c.logger().Error(context.Background(), "sendFile error : ", err.Error())
+	c.popTLSFrame()
+	c.queueFileNotify(f.ff, err)
+	runtime.KeepAlive(f.ff.file)
+	return err
+}
+
+// 连接关闭时还没有发送完
+func (f *tlsFileFrame) abort(c *Conn, err error) {
+	f.ff.abort(c, err)
+}
+
+// 在ET模式下发送队列头部的文件，直到发送完或者EAGAIN，返回是否发送完
+// 出错时返回的错误需要关闭连接，文件已经发送了一部分，之后的数据无法再按顺序发送
+func (ff *fileFrame) send(c *Conn) (bool, error) {
+	for ff.sent < ff.length {
+		var n int
+		var err error
+		if ff.buf == nil {
+			n, err = unix.Sendfile(c.fd, ff.fd, &ff.offset, int(ff.remain(maxSendfileSize)))
+			//文件不支持sendfile，改为pread与write
+			if err == unix.EINVAL || err == unix.ENOSYS || err == unix.EOPNOTSUPP {
+				ff.buf = make([]byte, maxSendfileSize)
+				continue
+			}
+			if err == nil && n == 0 {
+				//文件被截断，没有足够的数据
+				err = io.ErrUnexpectedEOF
+			}
+		} else {
+			n, err = c.writeFileFallback(ff)
+		}
+		if err != nil {
+			if err == unix.EINTR {
+				continue
+			}
+			if err == unix.EAGAIN || err == unix.EWOULDBLOCK {
+				c.queueFileNotify(ff, nil)
+				c.rearm()
+				return false, nil
+			}
+			c.logger().Error(context.Background(), "sendFile error : ", err.Error())
+			c.writeQueue.popOther()
+			c.queueFileNotify(ff, err)
+			runtime.KeepAlive(ff.file)
+			return false, err
+		}
+		ff.sent += int64(n)
+		c.writeQueue.consume(n)
+
+		c.touch()
+	}
+
+	c.writeQueue.popOther()
+	c.queueFileNotify(ff, nil)
+	runtime.KeepAlive(ff.file)
+	return true, nil
+}
+
+// 连接关闭时还没有发送完
+func (ff *fileFrame) abort(c *Conn, err error) {
+	runtime.KeepAlive(ff.file)
+	if ff.callback != nil {
+		ff.callback(c, ff.sent, ff.length, err)
+	}
+}
+
+// 不支持sendfile时，读取文件后写入，只有写入成功的部分才算发送
+func (c *Conn) writeFileFallback(ff *fileFrame) (int, error) {
+	n, err := preadFull(ff.fd, ff.buf[:ff.remain(int64(len(ff.buf)))], ff.offset)
+	if err != nil {
+		return 0, err
+	}
+	n, err = unix.Write(c.fd, ff.buf[:n])
+	if err != nil {
+		return 0, err
+	}
+	ff.offset += int64(n)
+	return n, nil
+}
+
+// 记录需要调用的进度回调，需要持有写锁，在释放写锁之后调用
+func (c *Conn) queueFileNotify(ff *fileFrame, err error) {
+	if ff.callback == nil || (err == nil && ff.sent == ff.notified) {
+		return
+	}
+	ff.notified = ff.sent
+	sent := ff.sent
+	c.writeNotify = append(c.writeNotify, func() {
+		ff.callback(c, sent, ff.length, err)
+	})
+}
+
+// 从文件的offset处读取数据，读到文件末尾时返回io.ErrUnexpectedEOF
+func preadFull(fd int, buf []byte, offset int64) (int, error) {
+	for {
+		n, err := unix.Pread(fd, buf, offset)
+		if err == unix.EINTR {
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		if n == 0 {
+			return 0, io.ErrUnexpectedEOF
+		}
+		return n, nil
+	}
+}
@@ -0,0 +1,90 @@
+package go_epoll
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+// 写入临时文件
+func writeTestFile(t *testing.T, data []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "sendfile")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// 文件与前后的数据按顺序发送，文件的长度计入Buffered，调用方不再引用文件时也不会被关闭
+func TestSendFile(t *testing.T) {
+	data := make([]byte, 8<<20)
+	rand.Read(data)
+	path := writeTestFile(t, data)
+
+	for _, useTLS := range []bool{false, true} {
+		name := "plain"
+		if useTLS {
+			name = "tls"
+		}
+		t.Run(name, func(t *testing.T) {
+			buffered := make(chan int, 1)
+			done := make(chan error, 1)
+			h := &testHandler{onData: func(c *Conn, _ []byte) {
+				f, err := os.Open(path)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				c.Write([]byte("HDR"))
+				err = c.SendFile(f, 0, 0, func(c *Conn, sent, length int64, err error) {
+					if sent == length || err != nil {
+						done <- err
+					}
+				})
+				if err != nil {
+					t.Error(err)
+				}
+				buffered <- c.Buffered()
+				c.Write([]byte("TRL"))
+				//不再引用文件，回收时会关闭fd
+				f = nil
+				runtime.GC()
+				runtime.GC()
+			}}
+			var opts []ServerOption
+			if useTLS {
+				opts = append(opts, WithTLSConfig(testTLSConfig(t)))
+			}
+			_, addr := newTestServer(t, h, opts...)
+			var c net.Conn = dialTestServer(t, addr)
+			c.(*net.TCPConn).SetReadBuffer(32 << 10)
+			if useTLS {
+				c = tls.Client(c, &tls.Config{InsecureSkipVerify: true})
+			}
+			c.Write([]byte("go"))
+
+			//对端还没有读取，文件还在写缓冲中
+			if n := waitChan(t, buffered); n < len(data)/2 {
+				t.Errorf("Buffered() = %d, want file length counted", n)
+			}
+			got := make([]byte, len(data)+6)
+			if _, err := io.ReadFull(c, got); err != nil {
+				t.Fatal(err)
+			}
+			want := append(append([]byte("HDR"), data...), "TRL"...)
+			if !bytes.Equal(got, want) {
+				t.Fatal("data mismatch")
+			}
+			if err := waitChan(t, done); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
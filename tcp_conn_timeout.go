@@ -0,0 +1,140 @@
+package go_epoll
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// 设置空闲超时时间，超过该时间没有读写就关闭连接，0表示不限制
+func (c *Conn) SetIdleTimeout(timeout time.Duration) {
+	atomic.StoreInt64(&c.idleTimeout, int64(timeout))
+	c.scheduleTimeout()
+}
+
+// 设置读超时时间，收到一条消息的部分数据后，超过该时间没有收完就关闭连接，0表示不限制
+func (c *Conn) SetReadTimeout(timeout time.Duration) {
+	atomic.StoreInt64(&c.readTimeout, int64(timeout))
+	c.scheduleTimeout()
+}
+
+// 设置写超时时间，写缓冲中有数据后，超过该时间没有发完就关闭连接，0表示不限制
+func (c *Conn) SetWriteTimeout(timeout time.Duration) {
+	atomic.StoreInt64(&c.writeTimeout, int64(timeout))
+	c.scheduleTimeout()
+}
+
+// 更新最后活动时间
+func (c *Conn) touch() {
+	atomic.StoreInt64(&c.lastActive, time.Now().UnixNano())
+}
+
+// 把连接放入时间轮
+func (c *Conn) scheduleTimeout() {
+	if c.IsClosed() || atomic.LoadInt32(&c.dialing) == 1 {
+		return
+	}
+	if next := c.nextTimeoutCheck(time.Now().UnixNano()); next > 0 {
+		c.server.wheel.Add(c, next)
+	}
+}
+
+// 时间轮到期回调，超时则关闭连接，否则返回下次检查的间隔
+func (c *Conn) checkTimeout() time.Duration {
+	if c.IsClosed() {
+		return 0
+	}
+	now := time.Now().UnixNano()
+	//正在连接，只检查连接超时
+	if atomic.LoadInt32(&c.dialing) == 1 {
+		deadline := atomic.LoadInt64(&c.deadline)
+		if deadline > 0 && now >= deadline {
+			if atomic.CompareAndSwapInt32(&c.dialing, 1, 0) {
+				c.dialFail(ConnDialTimeout)
+			}
+			return 0
+		}
+		return time.Duration(deadline - now)
+	}
+	if err := c.timeoutErr(now); err != nil {
+		//调用了CloseAfterFlush，数据已经发送完，只是对端没有关闭，正常关闭
+		if err == ConnFlushTimeout && atomic.LoadInt32(&c.writeShut) == writeShut {
+			err = nil
+		}
+		c.closeWithErr(err)
+		return 0
+	}
+	if err := c.checkHeartbeat(now); err != nil {
+		c.closeWithErr(err)
+		return 0
+	}
+	return c.nextTimeoutCheck(now)
+}
+
+// 判断是否超时
+func (c *Conn) timeoutErr(now int64) error {
+	if t := atomic.LoadInt64(&c.idleTimeout); t > 0 && now-atomic.LoadInt64(&c.lastActive) >= t {
+		return ConnIdleTimeout
+	}
+	if t := atomic.LoadInt64(&c.readTimeout); t > 0 {
+		if start := atomic.LoadInt64(&c.readStart); start > 0 && now-start >= t {
+			return ConnReadTimeout
+		}
+	}
+	if t := atomic.LoadInt64(&c.writeTimeout); t > 0 {
+		if start := atomic.LoadInt64(&c.writeStart); start > 0 && now-start >= t {
+			return ConnWriteTimeout
+		}
+	}
+	if t := atomic.LoadInt64(&c.writeFullTimeout); t > 0 {
+		if start := atomic.LoadInt64(&c.writeFullStart); start > 0 && now-start >= t {
+			return ConnWriteFullTimeout
+		}
+	}
+	if deadline := atomic.LoadInt64(&c.flushClose); deadline > 0 && now >= deadline {
+		return ConnFlushTimeout
+	}
+	return nil
+}
+
+// 计算下次检查的间隔，读写超时还没开始计时的，按照完整的超时时间计算，0表示不需要检查
+func (c *Conn) nextTimeoutCheck(now int64) time.Duration {
+	var next int64
+	min := func(d int64) {
+		if d <= 0 {
+			d = 1
+		}
+		if next == 0 || d < next {
+			next = d
+		}
+	}
+	if t := atomic.LoadInt64(&c.idleTimeout); t > 0 {
+		min(atomic.LoadInt64(&c.lastActive) + t - now)
+	}
+	if t := atomic.LoadInt64(&c.readTimeout); t > 0 {
+		if start := atomic.LoadInt64(&c.readStart); start > 0 {
+			min(start + t - now)
+		} else {
+			min(t)
+		}
+	}
+	if t := atomic.LoadInt64(&c.writeTimeout); t > 0 {
+		if start := atomic.LoadInt64(&c.writeStart); start > 0 {
+			min(start + t - now)
+		} else {
+			min(t)
+		}
+	}
+	if t := atomic.LoadInt64(&c.writeFullTimeout); t > 0 {
+		//只有超过高水位时才需要检查
+		if start := atomic.LoadInt64(&c.writeFullStart); start > 0 {
+			min(start + t - now)
+		}
+	}
+	if deadline := atomic.LoadInt64(&c.flushClose); deadline > 0 {
+		min(deadline - now)
+	}
+	if c.heartbeat.Load() != nil {
+		min(c.nextHeartbeatCheck(now))
+	}
+	return time.Duration(next)
+}
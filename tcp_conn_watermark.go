@@ -0,0 +1,175 @@
+package go_epoll
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// 可选接口，实现后写缓冲超过高水位时调用OnWriteBufferFull，之后降到低水位以下时调用OnWritable
+// 生产者可以在OnWriteBufferFull中暂停写入，在OnWritable中恢复
+type TcpServerWritableHandler interface {
+	OnWriteBufferFull(conn *Conn)
+	OnWritable(conn *Conn)
+}
+
+// 写缓冲超过高水位时Write的处理策略
+type WriteFullPolicy uint8
+
+const (
+	WriteFullError WriteFullPolicy = iota + 1 //返回WriteBufferFull
+	WriteFullBlock                            //阻塞直到降到低水位以下，在事件回调中使用时会阻塞这个连接的读事件处理
+)
+
+func (p WriteFullPolicy) String() string {
+	switch p {
+	case WriteFullError:
+		return "error"
+	case WriteFullBlock:
+		return "block"
+	}
+	return ""
+}
+
+func (p *WriteFullPolicy) UnmarshalText(text []byte) error {
+	switch strings.ToLower(string(text)) {
+	case "error":
+		*p = WriteFullError
+	case "block":
+		*p = WriteFullBlock
+	default:
+		//数字只能是定义的策略
+		n, err := strconv.Atoi(string(text))
+		if err != nil || (n != int(WriteFullError) && n != int(WriteFullBlock)) {
+			return fmt.Errorf("%w : write_full_policy %s unknown", InvalidConfig, text)
+		}
+		*p = WriteFullPolicy(n)
+	}
+	return nil
+}
+
+// 设置写缓冲的高低水位，high为0表示不限制，low为0时使用高水位的一半
+func (c *Conn) SetWriteWatermark(high, low int) {
+	if low <= 0 || low >= high {
+		low = high / 2
+	}
+	atomic.StoreInt64(&c.highWatermark, int64(high))
+	atomic.StoreInt64(&c.lowWatermark, int64(low))
+}
+
+// 设置超过高水位时Write的处理策略
+func (c *Conn) SetWriteFullPolicy(policy WriteFullPolicy) {
+	atomic.StoreInt32(&c.writeFullPolicy, int32(policy))
+}
+
+// 设置超过高水位的最长时间，超过后关闭连接，0表示不限制
+func (c *Conn) SetWriteFullTimeout(timeout time.Duration) {
+	atomic.StoreInt64(&c.writeFullTimeout, int64(timeout))
+	c.scheduleTimeout()
+}
+
+// 获取写缓冲中还没有发送的字节数，包含SendFile还没有发送的部分
+func (c *Conn) Buffered() int {
+	c.wLock.Lock()
+	defer c.wLock.Unlock()
+
+	if c.IsClosed() {
+		return 0
+	}
+	return c.writeQueue.Len()
+}
+
+// 写缓冲是否超过了高水位，还没有降到低水位以下
+func (c *Conn) IsWriteFull() bool {
+	return atomic.LoadInt64(&c.writeFullStart) != 0
+}
+
+// 超过高水位时，根据策略返回错误或者等待降到低水位以下
+func (c *Conn) waitWritable() error {
+	for {
+		c.wLock.Lock()
+		if !c.writeFull || c.IsClosed() {
+			c.wLock.Unlock()
+			return nil
+		}
+		if WriteFullPolicy(atomic.LoadInt32(&c.writeFullPolicy)) != WriteFullBlock {
+			c.wLock.Unlock()
+			return WriteBufferFull
+		}
+		if c.writable == nil {
+			c.writable = make(chan struct{})
+		}
+		writable := c.writable
+		c.wLock.Unlock()
+
+		//在OnData等事件回调中调用时，单次触发的事件已经取消注册，回调返回后才会重新注册，需要在等待之前注册可写事件
+		c.rearm()
+
+		select {
+		case <-writable:
+		case <-c.done:
+			return nil
+		}
+	}
+}
+
+// 检查是否超过了高水位，需要持有写锁，返回true时需要在释放写锁后调用onWriteBufferFull
+func (c *Conn) checkWriteFull() bool {
+	high := atomic.LoadInt64(&c.highWatermark)
+	if high <= 0 || c.writeFull || int64(c.writeQueue.Len()) < high {
+		return false
+	}
+	c.writeFull = true
+	atomic.StoreInt64(&c.writeFullStart, time.Now().UnixNano())
+	return true
+}
+
+// 检查是否降到了低水位以下，需要持有写锁，返回true时需要在释放写锁后调用onWritable
+func (c *Conn) checkWritable() bool {
+	if !c.writeFull || int64(c.writeQueue.Len()) > atomic.LoadInt64(&c.lowWatermark) {
+		return false
+	}
+	c.writeFull = false
+	atomic.StoreInt64(&c.writeFullStart, 0)
+	if c.writable != nil {
+		close(c.writable)
+		c.writable = nil
+	}
+	return true
+}
+
+// 超过高水位回调
+func (c *Conn) onWriteBufferFull() {
+	//开始计算超过高水位的时间
+	if atomic.LoadInt64(&c.writeFullTimeout) > 0 {
+		c.scheduleTimeout()
+	}
+	if h, ok := c.handler.(TcpServerWritableHandler); ok {
+		h.OnWriteBufferFull(c)
+	}
+}
+
+// 降到低水位以下回调
+func (c *Conn) onWritable() {
+	if h, ok := c.handler.(TcpServerWritableHandler); ok {
+		h.OnWritable(c)
+	}
+}
+
+// 发送写缓冲中的数据，降到低水位以下时调用OnWritable
+func (c *Conn) flush() {
+	if !c.incRef() {
+		return
+	}
+	defer c.decRef()
+
+	c.wLock.Lock()
+	if c.IsClosed() {
+		c.wLock.Unlock()
+		return
+	}
+	err := c.eventHandleWrite()
+	c.finishWrite(false, err)
+}
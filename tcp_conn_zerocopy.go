@@ -0,0 +1,234 @@
+package go_epoll
+
+import (
+	"context"
+	"sync/atomic"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// 零拷贝发送的状态
+const (
+	zeroCopyUnknown int32 = iota //还没有开启
+	zeroCopyOn                   //已经开启SO_ZEROCOPY
+	zeroCopyOff                  //socket不支持
+)
+
+// 零拷贝发送结束的回调，err为nil时内核已经不再使用数据，可以修改或者复用缓冲
+type ZeroCopyCallback func(conn *Conn, err error)
+
+// 写缓冲中使用MSG_ZEROCOPY发送的数据，不复制，发送完并且内核通知完成后才调用回调
+type zeroCopyFrame struct {
+	buf      []byte           //调用方的数据
+	sent     int              //已经发送的字节数
+	first    uint32           //第一次使用MSG_ZEROCOPY发送的序号
+	calls    uint32           //使用MSG_ZEROCOPY发送的次数，序号从first开始连续
+	pending  uint32           //还没有收到完成通知的次数
+	done     bool             //已经调用或者准备调用回调
+	callback ZeroCopyCallback //回调
+}
+
+// 发送数据，不小于ZeroCopyThreshold时使用MSG_ZEROCOPY，内核直接从p中发送，不复制
+// 与Write按顺序发送，callback调用之前不能修改或者复用p，callback可以为nil
+// 数据较小、开启了TLS、设置了编码或者socket不支持时复制后发送，写入后就调用callback
+// 连接关闭时callback返回错误，此时内核可能还在发送剩余的数据
+func (c *Conn) WriteZeroCopy(p []byte, callback ZeroCopyCallback) (int, error) {
+	threshold := c.server.cfg.ZeroCopyThreshold
+	if threshold <= 0 || len(p) < threshold || c.tls != nil || c.endecoder != nil || !c.enableZeroCopy() {
+		n, err := c.Write(p)
+		if callback != nil {
+			callback(c, err)
+		}
+		return n, err
+	}
+
+	if err := c.waitWritable(); err != nil {
+		return 0, err
+	}
+
+	if !c.incRef() {
+		return 0, ConnClosed
+	}
+	defer c.decRef()
+
+	c.wLock.Lock()
+	if c.IsClosed() {
+		c.wLock.Unlock()
+		return 0, ConnClosed
+	}
+	if atomic.LoadInt32(&c.writeShut) != writeOpen {
+		c.wLock.Unlock()
+		return 0, ConnWriteClosed
+	}
+	dialing := atomic.LoadInt32(&c.dialing) == 1
+	c.writeQueue.pushOther(&zeroCopyFrame{buf: p, callback: callback}, len(p))
+	var err error
+	if !dialing {
+		err = c.eventHandleWrite()
+	}
+	c.finishWrite(dialing, err)
+
+	return len(p), nil
+}
+
+// 开启SO_ZEROCOPY，返回是否支持
+func (c *Conn) enableZeroCopy() bool {
+	switch atomic.LoadInt32(&c.zeroCopy) {
+	case zeroCopyOn:
+		return true
+	case zeroCopyOff:
+		return false
+	}
+	if err := unix.SetsockoptInt(c.fd, unix.SOL_SOCKET, unix.SO_ZEROCOPY, 1); err != nil {
+		atomic.StoreInt32(&c.zeroCopy, zeroCopyOff)
+		return false
+	}
+	atomic.StoreInt32(&c.zeroCopy, zeroCopyOn)
+	return true
+}
+
+// 在ET模式下发送，直到发送完或者EAGAIN，返回是否发送完
+func (zf *zeroCopyFrame) send(c *Conn) (bool, error) {
+	for zf.sent < len(zf.buf) {
+		end := zf.sent + maxWritevSize
+		if end > len(zf.buf) {
+			end = len(zf.buf)
+		}
+		zeroCopy := true
+		n, err := unix.SendmsgN(c.fd, zf.buf[zf.sent:end], nil, nil, unix.MSG_ZEROCOPY)
+		if err == unix.ENOBUFS {
+			//超过了锁定内存的限制，这一部分复制后发送
+			zeroCopy = false
+			n, err = unix.Write(c.fd, zf.buf[zf.sent:end])
+		}
+		if err != nil {
+			if err == unix.EINTR {
+				continue
+			}
+			// 内核写缓冲区已满，重新注册事件，尝试再次写
+			if err == unix.EAGAIN || err == unix.EWOULDBLOCK {
+				c.rearm()
+				return false, nil
+			}
+			//关闭连接时回调返回这个错误
+			c.logger().Error(context.Background(), "sendZeroCopy error : ", err.Error())
+			return false, err
+		}
+		if zeroCopy {
+			//每次成功的发送使用一个序号，完成通知中是序号的范围
+			if zf.calls == 0 {
+				zf.first = c.zeroCopySeq
+				c.zeroCopyPending = append(c.zeroCopyPending, zf)
+			}
+			c.zeroCopySeq++
+			zf.calls++
+			zf.pending++
+		}
+		zf.sent += n
+		c.writeQueue.consume(n)
+
+		c.touch()
+	}
+
+	c.writeQueue.popOther()
+	c.completeZeroCopy(zf)
+	return true, nil
+}
+
+// 连接关闭时还没有完成
+func (zf *zeroCopyFrame) abort(c *Conn, err error) {
+	if zf.done {
+		return
+	}
+	zf.done = true
+	if zf.callback != nil {
+		zf.callback(c, err)
+	}
+}
+
+// 发送完并且内核已经通知完成时，从等待列表中删除，并记录需要调用的回调，需要持有写锁
+func (c *Conn) completeZeroCopy(zf *zeroCopyFrame) {
+	if zf.done || zf.pending > 0 || zf.sent < len(zf.buf) {
+		return
+	}
+	zf.done = true
+	for i, f := range c.zeroCopyPending {
+		if f == zf {
+			c.zeroCopyPending = append(c.zeroCopyPending[:i], c.zeroCopyPending[i+1:]...)
+			break
+		}
+	}
+	if zf.callback != nil {
+		c.writeNotify = append(c.writeNotify, func() {
+			zf.callback(c, nil)
+		})
+	}
+}
+
+// 读取错误队列中的完成通知，通知会触发出错事件，返回是否开启了零拷贝
+// 其它协程可能已经读完了通知，没有读到通知时也不表示出错
+func (c *Conn) readZeroCopyNotify() bool {
+	if atomic.LoadInt32(&c.zeroCopy) != zeroCopyOn {
+		return false
+	}
+
+	c.wLock.Lock()
+	oob := make([]byte, unix.CmsgSpace(int(unsafe.Sizeof(unix.SockExtendedErr{}))+unix.SizeofSockaddrInet6))
+	for {
+		_, oobn, _, _, err := unix.Recvmsg(c.fd, nil, oob, unix.MSG_ERRQUEUE)
+		if oobn > 0 {
+			c.handleZeroCopyNotify(oob[:oobn])
+		}
+		if err == unix.EINTR {
+			continue
+		}
+		//错误队列为空时返回EAGAIN
+		if oobn == 0 {
+			break
+		}
+	}
+	notify := c.takeWriteNotify()
+	c.wLock.Unlock()
+
+	for _, fn := range notify {
+		fn()
+	}
+	return true
+}
+
+// 解析完成通知，通知中[Info, Data]范围内序号的发送已经完成，需要持有写锁
+func (c *Conn) handleZeroCopyNotify(oob []byte) {
+	msgs, err := unix.ParseSocketControlMessage(oob)
+	if err != nil {
+		return
+	}
+	for _, m := range msgs {
+		if !(m.Header.Level == unix.SOL_IP && m.Header.Type == unix.IP_RECVERR) &&
+			!(m.Header.Level == unix.SOL_IPV6 && m.Header.Type == unix.IPV6_RECVERR) {
+			continue
+		}
+		if len(m.Data) < int(unsafe.Sizeof(unix.SockExtendedErr{})) {
+			continue
+		}
+		ee := (*unix.SockExtendedErr)(unsafe.Pointer(&m.Data[0]))
+		if ee.Origin != unix.SO_EE_ORIGIN_ZEROCOPY || ee.Errno != 0 {
+			continue
+		}
+
+		//通知可能乱序，按照每个缓冲的序号范围计算完成的次数
+		for _, zf := range append([]*zeroCopyFrame(nil), c.zeroCopyPending...) {
+			lo, hi := zf.first, zf.first+zf.calls-1
+			if ee.Info > lo {
+				lo = ee.Info
+			}
+			if ee.Data < hi {
+				hi = ee.Data
+			}
+			if lo <= hi {
+				zf.pending -= hi - lo + 1
+				c.completeZeroCopy(zf)
+			}
+		}
+	}
+}
@@ -2,44 +2,139 @@ package go_epoll
 
 import (
 	"context"
+	"crypto/tls"
+	"fmt"
 	"golang.org/x/sys/unix"
+	"net/netip"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type TcpServerHandler interface {
 	OnConnect(conn *Conn)
+	//data只在回调中有效，返回后会被之后收到的数据覆盖，需要保存时复制或者调用conn.Retain
 	OnData(conn *Conn, data []byte)
 	OnError(conn *Conn)
 	OnClose(conn *Conn)
 }
 
+// 可选接口，实现后关闭连接时调用OnCloseErr代替OnClose，err为关闭原因，如ConnIdleTimeout、ConnPeerClosed、unix.ECONNRESET，主动调用Close时为nil
+// 可以通过conn.CloseReason()获取分类后的原因
+type TcpServerCloseErrHandler interface {
+	OnCloseErr(conn *Conn, err error)
+}
+
+// 可选接口，实现后出错时调用OnErrorErr代替OnError，err为出错原因，如SO_ERROR中的unix.ECONNRESET、连接失败时的unix.ECONNREFUSED
+type TcpServerErrorErrHandler interface {
+	OnErrorErr(conn *Conn, err error)
+}
+
+// 连接数超出上限时的处理策略
+type OverflowPolicy uint8
+
+const (
+	OverflowReject OverflowPolicy = iota + 1 //接收连接后立即关闭
+	OverflowPause                            //暂停接收连接，直到连接数低于上限
+)
+
+func (p OverflowPolicy) String() string {
+	switch p {
+	case OverflowReject:
+		return "reject"
+	case OverflowPause:
+		return "pause"
+	}
+	return ""
+}
+
+func (p *OverflowPolicy) UnmarshalText(text []byte) error {
+	switch strings.ToLower(string(text)) {
+	case "reject":
+		*p = OverflowReject
+	case "pause":
+		*p = OverflowPause
+	default:
+		//数字只能是定义的策略
+		n, err := strconv.Atoi(string(text))
+		if err != nil || (n != int(OverflowReject) && n != int(OverflowPause)) {
+			return fmt.Errorf("%w : overflow_policy %s unknown", InvalidConfig, text)
+		}
+		*p = OverflowPolicy(n)
+	}
+	return nil
+}
+
 type TcpServer struct {
-	addr       string           //地址
-	fd         int              //文件描述符
-	reactor    *Reactor         //多路复用反应堆
-	handler    TcpServerHandler //回调函数
-	endecoder  EnDecoder        //编码解码
-	connManage *ConnManage      //连接管理
-	bufPool    *sync.Pool       //缓冲池，用于连接的读与写
-	stop       chan struct{}    //关闭通道
+	addr          string           //地址
+	fd            int              //文件描述符
+	reservedFD    int              //预留的文件描述符，用于处理EMFILE/ENFILE
+	cfg           *ServerConfig    //配置
+	reactor       *Reactor         //多路复用反应堆
+	handler       TcpServerHandler //回调函数
+	endecoder     EnDecoder        //编码解码
+	connManage    *ConnManage      //连接管理
+	dialManage    *ConnManage      //通过Dialer发起的连接管理，不计入最大连接数
+	bufPool       *sync.Pool       //缓冲池，用于连接的读与写
+	acceptLimiter *TokenBucket     //accept限流，速率 <= 0 时不限制
+	proxyTrusted  []netip.Prefix   //PROXY协议可信的上游
+	wheel         *timingWheel     //时间轮，用于检查连接超时
+	connFree      chan struct{}    //有连接关闭时通知accept循环
+	acceptStop    chan struct{}    //停止接收新连接
+	acceptOnce    sync.Once        //保证只停止一次
+	runOnce       sync.Once        //保证事件循环只启动一次
+	stop          chan struct{}    //关闭通道
+	//接收限制，可在运行时修改
+	maxConns       int64                     //最大连接数，0表示不限制
+	overflowPolicy int32                     //超出最大连接数时的处理策略
+	admission      atomic.Pointer[Admission] //准入控制，nil表示不检查
+	//统计相关
+	closeCounts [closeReasonCount]int64 //各种原因关闭的连接数量
 }
 
-func NewTcpServer(addr string, dType EventDemultiplexerType, dSize int, eventSize int, workCount int) (*TcpServer, error) {
+func NewTcpServer(addr string, opts ...ServerOption) (*TcpServer, error) {
 	var err error
 
+	cfg := DefaultServerConfig()
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	if err = cfg.Validate(); err != nil {
+		return nil, err
+	}
+
 	s := &TcpServer{
 		addr:       addr,
+		fd:         -1,
+		reservedFD: -1,
+		cfg:        cfg,
 		connManage: NewConnManage(),
+		dialManage: NewConnManage(),
 		bufPool: &sync.Pool{
 			New: func() any {
-				b := make([]byte, 1024)
+				b := make([]byte, cfg.BufferSize)
 				return NewBuffer(b)
 			},
 		},
-		stop: make(chan struct{}),
+		acceptLimiter: NewTokenBucket(cfg.AcceptRate, cfg.AcceptBurst),
+		connFree:      make(chan struct{}, 1),
+		acceptStop:    make(chan struct{}),
+		stop:          make(chan struct{}),
 	}
 
-	s.reactor, err = NewReactor(dType, dSize, eventSize, workCount)
+	s.SetMaxConns(cfg.MaxConns, cfg.OverflowPolicy)
+	s.SetAdmission(cfg.Admission)
+
+	s.wheel = newTimingWheel(cfg.TimeoutTick, 512, (*Conn).checkTimeout)
+
+	s.proxyTrusted, err = ParsePrefixes(cfg.ProxyProtocolTrusted)
+	if err != nil {
+		return nil, err
+	}
+
+	s.reactor, err = NewReactor(WithReactorConfig(cfg.ReactorConfig))
 	if err != nil {
 		return nil, err
 	}
@@ -47,6 +142,24 @@ func NewTcpServer(addr string, dType EventDemultiplexerType, dSize int, eventSiz
 	return s, nil
 }
 
+// 获取创建时的配置，SetMaxConns等运行时修改的值不会写回配置
+func (s *TcpServer) GetConfig() *ServerConfig {
+	return s.cfg
+}
+
+// 根据连接ID获取接受的连接，不包括通过Dialer发起的连接，连接关闭后返回false
+func (s *TcpServer) GetConn(id uint64) (*Conn, bool) {
+	return s.connManage.GetConnByID(id)
+}
+
+// 获取日志
+func (s *TcpServer) getLogger() *Logger {
+	if s.cfg.Logger != nil {
+		return s.cfg.Logger
+	}
+	return logger
+}
+
 // 设置回调函数
 func (s *TcpServer) SetHandler(handler TcpServerHandler) {
 	s.handler = handler
@@ -57,61 +170,180 @@ func (s *TcpServer) SetEnDecoder(endecoder EnDecoder) {
 	s.endecoder = endecoder
 }
 
-// 监听
-func (s *TcpServer) Listen() error {
-	var err error
-	// 创建监听socket
-	s.fd, err = unix.Socket(unix.AF_INET, unix.SOCK_STREAM|unix.SOCK_CLOEXEC, 0)
+// 设置最大连接数，以及超出上限时的处理策略，max <= 0 表示不限制，可在运行时调用
+func (s *TcpServer) SetMaxConns(max int, policy OverflowPolicy) {
+	if max < 0 {
+		max = 0
+	}
+	atomic.StoreInt32(&s.overflowPolicy, int32(policy))
+	atomic.StoreInt64(&s.maxConns, int64(max))
+}
+
+// 设置每秒最多接收的连接数，以及允许的突发数量，rate <= 0 表示不限制，可在运行时调用
+func (s *TcpServer) SetAcceptRate(rate float64, burst int) {
+	s.acceptLimiter.SetRate(rate, burst)
+}
+
+// 是否达到了最大连接数
+func (s *TcpServer) connsFull() bool {
+	max := atomic.LoadInt64(&s.maxConns)
+	return max > 0 && int64(s.connManage.Len()) >= max
+}
+
+// 设置准入控制，在创建连接之前按来源IP进行检查，可在运行时调用，已经接收的连接关闭时仍然在原来的准入控制上释放
+func (s *TcpServer) SetAdmission(admission *Admission) {
+	s.admission.Store(admission)
+}
+
+// 获取准入控制
+func (s *TcpServer) GetAdmission() *Admission {
+	return s.admission.Load()
+}
+
+// 设置TLS配置，开启TLS，需要在Run之前调用
+func (s *TcpServer) SetTLSConfig(config *tls.Config) {
+	s.cfg.TLSConfig = config
+}
+
+// 开启PROXY协议，trusted为可信的上游CIDR，为空表示信任所有，需要在Run之前调用
+func (s *TcpServer) SetProxyProtocol(enable bool, trusted ...string) error {
+	prefixes, err := ParsePrefixes(trusted)
 	if err != nil {
 		return err
 	}
-	// 重用socket地址
-	if err = s.ReuseAddr(s.fd); err != nil {
-		return err
+	s.cfg.ProxyProtocol = enable
+	s.cfg.ProxyProtocolTrusted = trusted
+	s.proxyTrusted = prefixes
+	return nil
+}
+
+// 是否需要解析来自该IP的PROXY协议头部
+func (s *TcpServer) expectProxyHeader(ip netip.Addr) bool {
+	if !s.cfg.ProxyProtocol {
+		return false
+	}
+	return len(s.proxyTrusted) == 0 || MatchPrefixes(s.proxyTrusted, ip)
+}
+
+// 设置socket选项，需要在Run之前调用，没有设置的字段使用DefaultSockOptions中的值
+func (s *TcpServer) SetSockOptions(opts *SockOptions) error {
+	if opts == nil {
+		return fmt.Errorf("%w : sock_options must not be nil", InvalidConfig)
 	}
-	// 重用socket端口
-	if err = s.ReusePort(s.fd); err != nil {
+	s.cfg.SockOptions = opts.withDefaults()
+	return nil
+}
+
+// 获取socket选项
+func (s *TcpServer) GetSockOptions() *SockOptions {
+	return s.cfg.SockOptions
+}
+
+// 监听
+func (s *TcpServer) Listen() error {
+	sa, domain, err := GetSockAddr(s.addr)
+	if err != nil {
 		return err
 	}
-	inet4, err := GetSockAddrInet4(s.addr)
+	// 创建监听socket
+	s.fd, err = unix.Socket(domain, unix.SOCK_STREAM|unix.SOCK_CLOEXEC, 0)
 	if err != nil {
 		return err
 	}
+	// 设置socket选项，如重用地址与端口
+	if err = s.cfg.SockOptions.applyListen(s.fd); err != nil {
+		return err
+	}
 	// 绑定地址
-	if err = unix.Bind(s.fd, inet4); err != nil {
+	if err = unix.Bind(s.fd, sa); err != nil {
 		return err
 	}
 	// 监听
-	if err = unix.Listen(s.fd, 1024); err != nil {
+	if err = unix.Listen(s.fd, s.cfg.SockOptions.backlog()); err != nil {
+		return err
+	}
+	// 预留一个文件描述符，当文件描述符耗尽时，用它来接收并关闭连接
+	s.reservedFD, err = openReservedFD()
+	if err != nil {
 		return err
 	}
 	return nil
 }
 
+// 打开预留的文件描述符
+func openReservedFD() (int, error) {
+	return unix.Open("/dev/null", unix.O_RDONLY|unix.O_CLOEXEC, 0)
+}
+
 // 接收连接
 func (s *TcpServer) Accept() (nfd int, addr string, err error) {
 	nfd, sa, err := unix.Accept(s.fd)
 	if err != nil {
-		return
-	}
-	err = unix.SetNonblock(nfd, true)
-	if err != nil {
+		if err == unix.EMFILE || err == unix.ENFILE {
+			s.shedAccept()
+		}
 		return
 	}
 
 	//转换成IP字符串
-	addr = GetIPBySockAddr(sa)
+	addrPort := GetAddrPortBySockAddr(sa)
+	addr = addrPort.String()
+	if !addrPort.IsValid() {
+		//unix socket的对端一般没有地址，使用监听地址
+		addr = s.addr
+	}
+
+	_, err = s.addConn(nfd, addr)
+	return
+}
+
+// 把已经建立的连接加入服务器，检查连接数与准入控制，失败时关闭nfd
+// addr不是"IP:端口"格式时为unix socket，不检查准入控制，也不设置TCP选项
+func (s *TcpServer) addConn(nfd int, addr string) (*Conn, error) {
+	addrPort := ParseAddrPort(addr)
+
+	//超出最大连接数，直接关闭
+	if s.connsFull() {
+		unix.Close(nfd)
+		s.getLogger().Warnf(context.Background(), "reject conn[%s] : %s", addr, ConnLimitExceeded.Error())
+		return nil, ConnLimitExceeded
+	}
+
+	//准入控制，记录在连接上，关闭时在同一个准入控制上释放
+	admission := s.admission.Load()
+	if !addrPort.IsValid() {
+		admission = nil
+	}
+	if admission != nil {
+		if err := admission.Admit(addrPort.Addr()); err != nil {
+			unix.Close(nfd)
+			s.getLogger().Warnf(context.Background(), "reject conn[%s] : %s", addr, err.Error())
+			return nil, ConnRejected
+		}
+	}
+
+	err := unix.SetNonblock(nfd, true)
+	if err == nil && addrPort.IsValid() {
+		err = s.cfg.SockOptions.applyConn(nfd)
+	}
+	if err != nil {
+		unix.Close(nfd)
+		releaseAdmission(admission, addrPort.Addr())
+		return nil, err
+	}
 
 	//创建连接
-	conn, err := NewConn(nfd, addr, s)
+	conn, err := newAcceptedConn(nfd, addr, s, admission)
 	if err != nil {
-		return
+		unix.Close(nfd)
+		releaseAdmission(admission, addrPort.Addr())
+		return nil, err
 	}
 
 	//添加连接
 	s.connManage.AddConn(conn)
 
-	return
+	return conn, nil
 }
 
 // 运行
@@ -121,35 +353,187 @@ func (s *TcpServer) Run() error {
 		return err
 	}
 
-	logger.Infof(context.Background(), "server[%s] run ...", s.addr)
+	return s.Serve()
+}
 
-	go s.reactor.Run()
+// 开始处理事件并接收连接，需要先调用Listen
+func (s *TcpServer) Serve() error {
+	s.getLogger().Infof(context.Background(), "server[%s] run ...", s.addr)
 
+	s.startLoop()
+
+	var delay time.Duration
 	for {
 		select {
 		case <-s.stop:
 			return nil
+		case <-s.acceptStop:
+			return nil
 		default:
-			_, _, err = s.Accept()
+			if !s.waitAccept() {
+				return nil
+			}
+			_, _, err := s.Accept()
 			if err != nil {
-				if err == unix.EINTR {
+				if err == unix.EINTR || err == ConnLimitExceeded || err == ConnRejected {
 					continue
 				}
-				logger.Error(context.Background(), "Accept error : ", err.Error())
+				//已经停止接收连接
+				if s.acceptStopped() {
+					return nil
+				}
+				s.getLogger().Error(context.Background(), "Accept error : ", err.Error())
+				//出错后退避一段时间，避免空转
+				if delay == 0 {
+					delay = 5 * time.Millisecond
+				} else if delay *= 2; delay > time.Second {
+					delay = time.Second
+				}
+				if !s.sleep(delay) {
+					return nil
+				}
+				continue
+			}
+			delay = 0
+		}
+	}
+}
+
+// 启动反应堆与时间轮，Serve与Dialer共用，只启动一次
+func (s *TcpServer) startLoop() {
+	s.runOnce.Do(func() {
+		go s.reactor.Run()
+
+		s.wheel.Run()
+	})
+}
+
+// 是否已经关闭
+func (s *TcpServer) closed() bool {
+	select {
+	case <-s.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// 停止接收新连接，已经接收的连接不受影响
+func (s *TcpServer) stopAccept() {
+	s.acceptOnce.Do(func() {
+		close(s.acceptStop)
+		//唤醒阻塞在accept上的协程
+		if s.fd >= 0 {
+			unix.Shutdown(s.fd, unix.SHUT_RD)
+		}
+	})
+}
+
+// 是否已经停止接收新连接
+func (s *TcpServer) acceptStopped() bool {
+	select {
+	case <-s.acceptStop:
+		return true
+	default:
+		return false
+	}
+}
+
+// 等待可以接收连接，返回false表示服务器已关闭
+func (s *TcpServer) waitAccept() bool {
+	//连接数达到上限，暂停接收，有连接关闭时或者定时重新检查，上限可能在运行时修改
+	if OverflowPolicy(atomic.LoadInt32(&s.overflowPolicy)) == OverflowPause && s.connsFull() {
+		t := time.NewTimer(100 * time.Millisecond)
+		defer t.Stop()
+		for s.connsFull() {
+			select {
+			case <-s.stop:
+				return false
+			case <-s.connFree:
+				if !t.Stop() {
+					<-t.C
+				}
+			case <-t.C:
 			}
+			t.Reset(100 * time.Millisecond)
 		}
 	}
+	//限流
+	if d := s.acceptLimiter.Reserve(); d > 0 {
+		return s.sleep(d)
+	}
+	return true
+}
+
+// 睡眠一段时间，返回false表示服务器已关闭
+func (s *TcpServer) sleep(d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-s.stop:
+		return false
+	case <-t.C:
+		return true
+	}
+}
+
+// 释放准入控制占用的连接数
+func releaseAdmission(admission *Admission, ip netip.Addr) {
+	if admission != nil {
+		admission.Release(ip)
+	}
+}
+
+// 通知有连接关闭
+func (s *TcpServer) notifyConnFree() {
+	select {
+	case s.connFree <- struct{}{}:
+	default:
+	}
+}
+
+// 文件描述符耗尽时，先关闭预留的文件描述符，接收连接后立即关闭，再重新预留，避免连接一直堆积在队列中导致空转
+func (s *TcpServer) shedAccept() {
+	if s.reservedFD < 0 {
+		return
+	}
+	unix.Close(s.reservedFD)
+	s.reservedFD = -1
+
+	if nfd, sa, err := unix.Accept(s.fd); err == nil {
+		unix.Close(nfd)
+		s.getLogger().Warnf(context.Background(), "reject conn[%s] : too many open files", GetIPBySockAddr(sa))
+	}
+
+	fd, err := openReservedFD()
+	if err != nil {
+		s.getLogger().Error(context.Background(), "open reserved fd error : ", err.Error())
+		return
+	}
+	s.reservedFD = fd
 }
 
 // 关闭
 func (s *TcpServer) Close() {
-	s.stop <- struct{}{}
+	//accept循环可能已经退出，这里不能阻塞发送
 	close(s.stop)
+	s.stopAccept()
 
-	unix.Close(s.fd)
+	//只发起连接或者监听失败时没有监听的socket
+	if s.fd >= 0 {
+		unix.Close(s.fd)
+	}
+
+	if s.reservedFD >= 0 {
+		unix.Close(s.reservedFD)
+	}
 
 	s.connManage.Close()
 
+	s.dialManage.Close()
+
+	s.wheel.Close()
+
 	s.reactor.Close()
 }
 
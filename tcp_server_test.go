@@ -0,0 +1,119 @@
+package go_epoll
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// 测试用的回调函数，没有设置的回调不做任何事
+type testHandler struct {
+	onConnect func(c *Conn)
+	onData    func(c *Conn, data []byte)
+	onClose   func(c *Conn, err error)
+}
+
+func (h *testHandler) OnConnect(c *Conn) {
+	if h.onConnect != nil {
+		h.onConnect(c)
+	}
+}
+
+func (h *testHandler) OnData(c *Conn, data []byte) {
+	if h.onData != nil {
+		h.onData(c, data)
+	}
+}
+
+func (h *testHandler) OnError(c *Conn) {
+}
+
+func (h *testHandler) OnClose(c *Conn) {
+}
+
+func (h *testHandler) OnCloseErr(c *Conn, err error) {
+	if h.onClose != nil {
+		h.onClose(c, err)
+	}
+}
+
+// 启动监听本地随机端口的服务器，测试结束时关闭，返回监听地址
+func newTestServer(t *testing.T, h TcpServerHandler, opts ...ServerOption) (*TcpServer, string) {
+	t.Helper()
+	s, err := NewTcpServer("127.0.0.1:0", opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.SetHandler(h)
+	return s, serveTestServer(t, s)
+}
+
+// 监听并开始接收连接，需要设置的回调与编码要在这之前设置
+func serveTestServer(t *testing.T, s *TcpServer) string {
+	t.Helper()
+	if err := s.Listen(); err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve()
+	t.Cleanup(s.Close)
+
+	sa, err := unix.Getsockname(s.fd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return GetNetAddrBySockAddr(sa).String()
+}
+
+// 连接测试服务器，测试结束时关闭
+func dialTestServer(t *testing.T, addr string) net.Conn {
+	t.Helper()
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+// 等待回调通知，超时时测试失败
+func waitChan[T any](t *testing.T, ch <-chan T) T {
+	t.Helper()
+	select {
+	case v := <-ch:
+		return v
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout")
+	}
+	var zero T
+	return zero
+}
+
+// 生成自签名证书的TLS配置
+func testTLSConfig(t *testing.T) *tls.Config {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}
+}
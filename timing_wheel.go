@@ -0,0 +1,113 @@
+package go_epoll
+
+import (
+	"sync"
+	"time"
+)
+
+// 时间轮，所有连接共用一个协程检查超时，不需要每个连接一个定时器
+// 连接有活动时只更新时间戳，不移动时间轮中的位置，到期时再检查真正的截止时间，没到期就重新放入
+type timingWheel struct {
+	tick   time.Duration               //每格的时间
+	slots  []map[*Conn]int             //每格中的连接，值为还需要转几圈
+	where  map[*Conn]int               //连接所在的格
+	pos    int                         //当前指针位置
+	expire func(c *Conn) time.Duration //到期回调，返回下次检查的间隔，<= 0 表示不再检查
+	lock   sync.Mutex                  //锁
+	once   sync.Once                   //保证只启动一次
+	stop   chan struct{}               //关闭通道
+}
+
+func newTimingWheel(tick time.Duration, slotNum int, expire func(c *Conn) time.Duration) *timingWheel {
+	slots := make([]map[*Conn]int, slotNum)
+	for i := range slots {
+		slots[i] = make(map[*Conn]int)
+	}
+	return &timingWheel{
+		tick:   tick,
+		slots:  slots,
+		where:  make(map[*Conn]int),
+		expire: expire,
+		stop:   make(chan struct{}),
+	}
+}
+
+// 运行
+func (tw *timingWheel) Run() {
+	tw.once.Do(func() {
+		go func() {
+			ticker := time.NewTicker(tw.tick)
+			defer ticker.Stop()
+
+			for {
+				select {
+				case <-tw.stop:
+					return
+				case <-ticker.C:
+					tw.advance()
+				}
+			}
+		}()
+	})
+}
+
+// 关闭
+func (tw *timingWheel) Close() {
+	close(tw.stop)
+}
+
+// 添加连接，delay后检查，已经在时间轮中的连接会被移动
+func (tw *timingWheel) Add(c *Conn, delay time.Duration) {
+	tw.lock.Lock()
+	defer tw.lock.Unlock()
+
+	tw.remove(c)
+
+	ticks := int((delay + tw.tick - 1) / tw.tick)
+	if ticks < 1 {
+		ticks = 1
+	}
+	slot := (tw.pos + ticks) % len(tw.slots)
+	tw.slots[slot][c] = (ticks - 1) / len(tw.slots)
+	tw.where[c] = slot
+}
+
+// 删除连接
+func (tw *timingWheel) Remove(c *Conn) {
+	tw.lock.Lock()
+	defer tw.lock.Unlock()
+
+	tw.remove(c)
+}
+
+func (tw *timingWheel) remove(c *Conn) {
+	if slot, ok := tw.where[c]; ok {
+		delete(tw.slots[slot], c)
+		delete(tw.where, c)
+	}
+}
+
+// 指针前进一格，处理到期的连接
+func (tw *timingWheel) advance() {
+	tw.lock.Lock()
+	tw.pos = (tw.pos + 1) % len(tw.slots)
+	expired := make([]*Conn, 0)
+	slot := tw.slots[tw.pos]
+	for c, rounds := range slot {
+		if rounds > 0 {
+			slot[c] = rounds - 1
+			continue
+		}
+		delete(slot, c)
+		delete(tw.where, c)
+		expired = append(expired, c)
+	}
+	tw.lock.Unlock()
+
+	//在锁外执行回调，回调中可能会关闭连接
+	for _, c := range expired {
+		if next := tw.expire(c); next > 0 {
+			tw.Add(c, next)
+		}
+	}
+}
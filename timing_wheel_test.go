@@ -0,0 +1,99 @@
+package go_epoll
+
+import (
+	"io"
+	"testing"
+	"time"
+)
+
+// 添加后经过多少格到期，超过一圈的按圈数计算
+func TestTimingWheelAdd(t *testing.T) {
+	tests := []struct {
+		delay time.Duration
+		ticks int
+	}{
+		{0, 1},
+		{time.Millisecond, 1},
+		{10 * time.Millisecond, 1},
+		{11 * time.Millisecond, 2},
+		{40 * time.Millisecond, 4},
+		{50 * time.Millisecond, 5},
+		{90 * time.Millisecond, 9},
+	}
+	for _, tt := range tests {
+		var fired []int
+		tick := 0
+		tw := newTimingWheel(10*time.Millisecond, 4, func(c *Conn) time.Duration {
+			fired = append(fired, tick)
+			return 0
+		})
+		tw.Add(&Conn{}, tt.delay)
+		for tick = 1; tick <= 12; tick++ {
+			tw.advance()
+		}
+		if len(fired) != 1 || fired[0] != tt.ticks {
+			t.Errorf("delay %v: fired at %v, want [%d]", tt.delay, fired, tt.ticks)
+		}
+	}
+}
+
+// 重新添加会移动位置，删除后不再到期，回调返回的间隔会重新放入
+func TestTimingWheelMoveRemove(t *testing.T) {
+	tick := 0
+	fired := make(map[*Conn][]int)
+	next := make(map[*Conn]time.Duration)
+	tw := newTimingWheel(10*time.Millisecond, 8, func(c *Conn) time.Duration {
+		fired[c] = append(fired[c], tick)
+		return next[c]
+	})
+	moved, removed, repeat := &Conn{}, &Conn{}, &Conn{}
+	tw.Add(moved, 20*time.Millisecond)
+	tw.Add(removed, 20*time.Millisecond)
+	tw.Add(repeat, 20*time.Millisecond)
+	next[repeat] = 30 * time.Millisecond
+
+	for tick = 1; tick <= 10; tick++ {
+		//第一格之前移动到5格之后
+		if tick == 1 {
+			tw.Add(moved, 50*time.Millisecond)
+			tw.Remove(removed)
+		}
+		tw.advance()
+	}
+	if got := fired[moved]; len(got) != 1 || got[0] != 5 {
+		t.Errorf("moved fired at %v, want [5]", got)
+	}
+	if got := fired[removed]; len(got) != 0 {
+		t.Errorf("removed fired at %v", got)
+	}
+	if got := fired[repeat]; len(got) != 3 || got[0] != 2 || got[1] != 5 || got[2] != 8 {
+		t.Errorf("repeat fired at %v, want [2 5 8]", got)
+	}
+	if len(tw.where) != 1 {
+		t.Errorf("where = %d conns, want 1", len(tw.where))
+	}
+}
+
+// 空闲超时关闭连接，有数据时重新计时
+func TestConnIdleTimeout(t *testing.T) {
+	closed := make(chan error, 1)
+	h := &testHandler{onClose: func(c *Conn, err error) { closed <- err }}
+	_, addr := newTestServer(t, h, WithIdleTimeout(200*time.Millisecond), WithTimeoutTick(10*time.Millisecond))
+	c := dialTestServer(t, addr)
+
+	start := time.Now()
+	for i := 0; i < 4; i++ {
+		time.Sleep(100 * time.Millisecond)
+		c.Write([]byte("x"))
+	}
+	if err := waitChan(t, closed); err != ConnIdleTimeout {
+		t.Fatalf("close err = %v, want ConnIdleTimeout", err)
+	}
+	if d := time.Since(start); d < 500*time.Millisecond {
+		t.Fatalf("closed after %v, activity did not extend the timeout", d)
+	}
+	c.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := c.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("client read = %v, want EOF", err)
+	}
+}
@@ -0,0 +1,376 @@
+package go_epoll
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// 握手完成后，传输层没有数据可读时返回的错误
+// crypto/tls遇到临时错误时不会把连接置为失败状态，等有新数据到达后可以继续读
+type tlsWouldBlock struct{}
+
+func (tlsWouldBlock) Error() string   { return "tls transport would block" }
+func (tlsWouldBlock) Timeout() bool   { return true }
+func (tlsWouldBlock) Temporary() bool { return true }
+
+// 内存中的传输层，实现了net.Conn，crypto/tls通过它读写密文
+// 密文由反应堆从fd中读取后写入in，加密后的数据通过Conn的写缓冲发送
+type tlsTransport struct {
+	c        *Conn        //所属连接
+	conn     *tls.Conn    //TLS连接
+	in       bytes.Buffer //从fd中读取的密文
+	plain    []byte       //解密后的数据
+	blocking bool         //握手期间，没有数据时阻塞读
+	closed   bool         //是否已关闭
+	lock     sync.Mutex   //锁
+	cond     *sync.Cond   //握手期间等待数据
+	readLock sync.Mutex   //保证解密后的数据按顺序处理
+	//写缓冲中等待加密的部分，如发送的文件，之后写入的数据也要等它们加密后再加密，TLS记录按加密的顺序发送
+	encLock  sync.Mutex //加密的锁，持有时才可以调用conn.Write
+	deferred int32      //写缓冲中等待加密的数量
+	capture  bool       //加密时不写入写缓冲，密文保存到out中，需要持有lock
+	out      []byte     //捕获的密文，需要持有lock
+}
+
+func newTLSTransport(c *Conn, config *tls.Config) *tlsTransport {
+	t := &tlsTransport{
+		c:        c,
+		plain:    make([]byte, c.server.cfg.ReadChunkSize),
+		blocking: true,
+	}
+	t.cond = sync.NewCond(&t.lock)
+	t.conn = tls.Server(t, config)
+	return t
+}
+
+// 读取密文，握手期间没有数据时阻塞，握手完成后返回临时错误
+func (t *tlsTransport) Read(p []byte) (int, error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	for t.in.Len() == 0 {
+		if t.closed {
+			return 0, io.EOF
+		}
+		if !t.blocking {
+			return 0, tlsWouldBlock{}
+		}
+		t.cond.Wait()
+	}
+	return t.in.Read(p)
+}
+
+// 写入密文，交给连接的写缓冲发送，发送写缓冲中等待加密的部分时由调用方发送
+func (t *tlsTransport) Write(p []byte) (int, error) {
+	t.lock.Lock()
+	if t.capture {
+		t.out = append(t.out, p...)
+		t.lock.Unlock()
+		return len(p), nil
+	}
+	t.lock.Unlock()
+	return t.c.writeRaw(p)
+}
+
+// 关闭，唤醒等待握手数据的协程
+func (t *tlsTransport) Close() error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.closed = true
+	t.cond.Broadcast()
+	return nil
+}
+
+func (t *tlsTransport) LocalAddr() net.Addr {
+	return t.c.LocalAddr()
+}
+
+func (t *tlsTransport) RemoteAddr() net.Addr {
+	return t.c.RemoteAddr()
+}
+
+func (t *tlsTransport) SetDeadline(time.Time) error {
+	return nil
+}
+
+func (t *tlsTransport) SetReadDeadline(time.Time) error {
+	return nil
+}
+
+func (t *tlsTransport) SetWriteDeadline(time.Time) error {
+	return nil
+}
+
+// 写入从fd中读取的密文，握手完成后直接解密
+func (t *tlsTransport) feed(p []byte) {
+	t.lock.Lock()
+	t.in.Write(p)
+	blocking := t.blocking
+	t.cond.Broadcast()
+	t.lock.Unlock()
+
+	if !blocking {
+		t.drain()
+	}
+}
+
+// 握手，握手会多次往返，所以在单独的协程中执行，不占用工作池的协程
+func (t *tlsTransport) handshake() {
+	//握手期间持有引用，握手完成后在这个协程中处理已经到达的数据
+	if !t.c.incRef() {
+		return
+	}
+	defer t.c.decRef()
+
+	ctx := context.Background()
+	if timeout := t.c.server.cfg.TLSHandshakeTimeout; timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	if err := t.conn.HandshakeContext(ctx); err != nil {
+		t.c.logger().Warnf(context.Background(), "conn[%s] tls handshake error : %s", t.c.addr, err.Error())
+		t.c.closeWithErr(fmt.Errorf("%w : %w", TLSFailed, err))
+		return
+	}
+
+	//与连接的事件处理按顺序执行
+	t.c.eventLock.Lock()
+	defer t.c.eventLock.Unlock()
+
+	t.lock.Lock()
+	t.blocking = false
+	t.lock.Unlock()
+
+	//握手完成，才算连接成功
+	t.c.onConnect()
+
+	//处理握手期间已经到达的数据
+	t.drain()
+}
+
+// 解密所有已经到达的完整记录，并交给连接处理
+// 暂停了读取时停止，剩余的记录留在TLS连接中，恢复时再解密
+func (t *tlsTransport) drain() {
+	t.readLock.Lock()
+	defer t.readLock.Unlock()
+
+	for {
+		if atomic.LoadInt32(&t.c.readPaused) == 1 {
+			return
+		}
+		n, err := t.conn.Read(t.plain)
+		if n > 0 {
+			t.c.process(t.plain[:n])
+		}
+		if err != nil {
+			var wb tlsWouldBlock
+			if errors.As(err, &wb) {
+				return
+			}
+			if err == io.EOF {
+				t.c.closeWithErr(ConnPeerClosed)
+				return
+			}
+			t.c.logger().Error(context.Background(), "tls read error : ", err.Error())
+			t.c.closeWithErr(fmt.Errorf("%w : %w", TLSFailed, err))
+			return
+		}
+	}
+}
+
+// 恢复读取时解密已经到达的记录，握手还没有完成时由握手协程处理
+func (t *tlsTransport) resume() {
+	t.lock.Lock()
+	blocking := t.blocking
+	t.lock.Unlock()
+
+	if !blocking {
+		t.drain()
+	}
+}
+
+// 加密并发送数据，写缓冲中还有等待加密的部分时，复制后放在它们之后，发送时再加密
+func (t *tlsTransport) write(p []byte) (int, error) {
+	t.encLock.Lock()
+	if atomic.LoadInt32(&t.deferred) == 0 {
+		defer t.encLock.Unlock()
+		return t.conn.Write(p)
+	}
+	full, err := t.c.pushTLSFrame(&tlsDataFrame{data: append([]byte(nil), p...)}, len(p))
+	t.encLock.Unlock()
+
+	if full {
+		t.c.onWriteBufferFull()
+	}
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// 发送close_notify，与write一样按顺序发送
+func (t *tlsTransport) closeWrite() error {
+	t.encLock.Lock()
+	if atomic.LoadInt32(&t.deferred) == 0 {
+		defer t.encLock.Unlock()
+		return t.conn.CloseWrite()
+	}
+	_, err := t.c.pushTLSFrame(&tlsDataFrame{closeWrite: true}, 0)
+	t.encLock.Unlock()
+	return err
+}
+
+// 在发送写缓冲中等待加密的部分时调用，需要持有写锁，执行fn加密并返回密文，密文由调用方直接发送
+// 其它协程正在加密时不等待，返回false，稍后重试，避免与持有加密锁等待写锁的协程互相等待
+func (t *tlsTransport) seal(fn func() error) ([]byte, bool, error) {
+	if !t.encLock.TryLock() {
+		return nil, false, nil
+	}
+	defer t.encLock.Unlock()
+
+	t.lock.Lock()
+	t.capture = true
+	t.lock.Unlock()
+
+	err := fn()
+
+	t.lock.Lock()
+	out := t.out
+	t.out = nil
+	t.capture = false
+	t.lock.Unlock()
+	return out, true, err
+}
+
+// 写缓冲中等待加密的数据，到达队列头部时才加密
+type tlsDataFrame struct {
+	data       []byte //还没有加密的数据
+	closeWrite bool   //发送close_notify
+	out        []byte //已经加密还没有发送的密文
+	sealed     bool   //是否已经加密
+}
+
+// 加密并发送，直到发送完或者EAGAIN
+func (f *tlsDataFrame) send(c *Conn) (bool, error) {
+	if !f.sealed {
+		out, ok, err := c.tls.seal(func() error {
+			if f.closeWrite {
+				return c.tls.conn.CloseWrite()
+			}
+			_, err := c.tls.conn.Write(f.data)
+			return err
+		})
+		if !ok {
+			c.rearm()
+			return false, nil
+		}
+		if err != nil {
+			c.popTLSFrame()
+			return false, err
+		}
+		f.out, f.sealed = out, true
+	}
+
+	out, err := c.writeSealed(f.out)
+	f.out = out
+	if err == unix.EAGAIN || err == unix.EWOULDBLOCK {
+		c.rearm()
+		return false, nil
+	}
+	c.popTLSFrame()
+	if err != nil {
+		c.logger().Error(context.Background(), "tls write error : ", err.Error())
+		return false, err
+	}
+	c.writeQueue.consume(len(f.data))
+	return true, nil
+}
+
+// 连接关闭时还没有发送完，数据是复制的，没有需要释放的资源，也没有回调，完成回调由之后的notifyFrame调用
+func (f *tlsDataFrame) abort(c *Conn, err error) {
+}
+
+// 把等待加密的部分放入写缓冲，需要持有加密锁，不在这里发送，注册可写事件后由事件协程加密并发送
+// 返回true时超过了高水位，需要在释放加密锁之后调用onWriteBufferFull，回调中可能会再次写入
+func (c *Conn) pushTLSFrame(f sendFrame, size int) (bool, error) {
+	if !c.incRef() {
+		return false, ConnClosed
+	}
+	defer c.decRef()
+
+	c.wLock.Lock()
+	defer c.wLock.Unlock()
+
+	if c.IsClosed() {
+		return false, ConnClosed
+	}
+	if atomic.LoadInt32(&c.writeShut) != writeOpen {
+		return false, ConnWriteClosed
+	}
+	c.writeQueue.pushOther(f, size)
+	atomic.AddInt32(&c.tls.deferred, 1)
+	if atomic.LoadInt64(&c.writeStart) == 0 {
+		atomic.StoreInt64(&c.writeStart, time.Now().UnixNano())
+		c.rearm()
+	}
+	return c.checkWriteFull(), nil
+}
+
+// 移除队列头部等待加密的部分，需要持有写锁
+func (c *Conn) popTLSFrame() {
+	c.writeQueue.popOther()
+	atomic.AddInt32(&c.tls.deferred, -1)
+}
+
+// 发送加密后的数据，返回没有发送完的部分，EAGAIN时返回剩余的数据与错误
+func (c *Conn) writeSealed(out []byte) ([]byte, error) {
+	for len(out) > 0 {
+		n, err := unix.Write(c.fd, out)
+		if err == unix.EINTR {
+			continue
+		}
+		if err != nil {
+			return out, err
+		}
+		if n == 0 {
+			return out, ConnPeerClosed
+		}
+		out = out[n:]
+		c.touch()
+	}
+	return nil, nil
+}
+
+// 根据SNI选择证书，支持 *.example.com 这样的通配符，没有匹配时使用def，def为nil时返回错误
+// 结果可以设置到tls.Config.GetCertificate
+func SNICertificates(certs map[string]*tls.Certificate, def *tls.Certificate) func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		name := strings.ToLower(strings.TrimSuffix(hello.ServerName, "."))
+		if cert, ok := certs[name]; ok {
+			return cert, nil
+		}
+		if i := strings.IndexByte(name, '.'); i > 0 {
+			if cert, ok := certs["*"+name[i:]]; ok {
+				return cert, nil
+			}
+		}
+		if def != nil {
+			return def, nil
+		}
+		return nil, TLSCertificateNotFound
+	}
+}
@@ -13,6 +13,9 @@ import (
 )
 
 // 获取连接的FD
+//
+// Deprecated: 通过反射读取私有字段，在不同的Go版本中可能失效，并且与Go的netpoller共用fd，
+// 使用DupFD，或者TcpServer.AdoptConn、TcpServer.AdoptListener
 func GetSocketFD(ln interface{}) (int, error) {
 	//通过反射，获取类型
 	t := reflect.Indirect(reflect.ValueOf(ln)).Type().String()
@@ -38,12 +41,14 @@ func GetSocketFD(ln interface{}) (int, error) {
 	return 0, errors.New(fmt.Sprintf("get socket fd error type : %s", t))
 }
 
+// Deprecated: 使用DupFD
 func PointerTCPFD(ln interface{}) int {
 	fdVal := reflect.Indirect(reflect.ValueOf(ln)).FieldByName("fd")
 	pfdVal := reflect.Indirect(fdVal).FieldByName("pfd")
 	return int(pfdVal.FieldByName("Sysfd").Int())
 }
 
+// Deprecated: 使用DupFD
 func ValueTCPFD(ln reflect.Value) int {
 	fdVal := ln.FieldByName("fd")
 	pfdVal := reflect.Indirect(fdVal).FieldByName("pfd")
@@ -93,21 +98,86 @@ func GetSockAddrInet4(addr string) (*unix.SockaddrInet4, error) {
 	return inet4, nil
 }
 
+// 获取地址，支持IPv4与IPv6，同时返回地址族
+func GetSockAddr(addr string) (unix.Sockaddr, int, error) {
+	addrPort, err := netip.ParseAddrPort(addr)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	ip := addrPort.Addr()
+	if ip.Is4() {
+		return &unix.SockaddrInet4{
+			Port: int(addrPort.Port()),
+			Addr: ip.As4(),
+		}, unix.AF_INET, nil
+	}
+
+	inet6 := &unix.SockaddrInet6{
+		Port: int(addrPort.Port()),
+		Addr: ip.As16(),
+	}
+	if zone := ip.Zone(); zone != "" {
+		if ifi, err := net.InterfaceByName(zone); err == nil {
+			inet6.ZoneId = uint32(ifi.Index)
+		}
+	}
+	return inet6, unix.AF_INET6, nil
+}
+
+// 获取IP与端口
+func GetAddrPortBySockAddr(sa unix.Sockaddr) netip.AddrPort {
+	switch sa := sa.(type) {
+	case *unix.SockaddrInet4:
+		return netip.AddrPortFrom(netip.AddrFrom4(sa.Addr), uint16(sa.Port))
+	case *unix.SockaddrInet6:
+		return netip.AddrPortFrom(netip.AddrFrom16(sa.Addr).Unmap(), uint16(sa.Port))
+	}
+	return netip.AddrPort{}
+}
+
+// 从"IP:端口"格式的地址中解析出IP与端口
+func ParseAddrPort(addr string) netip.AddrPort {
+	addrPort, err := netip.ParseAddrPort(addr)
+	if err != nil {
+		return netip.AddrPort{}
+	}
+	return addrPort
+}
+
+// 从"IP:端口"格式的地址中解析出IP
+func ParseIP(addr string) netip.Addr {
+	return ParseAddrPort(addr).Addr()
+}
+
+// 获取socket本端的IP与端口
+func GetLocalAddrPort(fd int) netip.AddrPort {
+	sa, err := unix.Getsockname(fd)
+	if err != nil {
+		return netip.AddrPort{}
+	}
+	return GetAddrPortBySockAddr(sa)
+}
+
+// 转换成net.Addr，支持IPv4、IPv6与unix socket
+func GetNetAddrBySockAddr(sa unix.Sockaddr) net.Addr {
+	if sa, ok := sa.(*unix.SockaddrUnix); ok {
+		return &net.UnixAddr{Name: sa.Name, Net: "unix"}
+	}
+	return net.TCPAddrFromAddrPort(GetAddrPortBySockAddr(sa))
+}
+
 // 获取IP
 func GetIPBySockAddr(sa unix.Sockaddr) string {
-	inet4 := sa.(*unix.SockaddrInet4)
-	addr := net.IPv4(inet4.Addr[0], inet4.Addr[1], inet4.Addr[2], inet4.Addr[3]).To4().String()
-	return fmt.Sprintf("%s:%d", addr, inet4.Port)
+	return GetAddrPortBySockAddr(sa).String()
 }
 
 // 设置最大打开文件描述符数量
-func SetLimit() {
+func SetLimit() error {
 	var rLimit syscall.Rlimit
 	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &rLimit); err != nil {
-		panic(err)
+		return err
 	}
 	rLimit.Cur = rLimit.Max
-	if err := syscall.Setrlimit(syscall.RLIMIT_NOFILE, &rLimit); err != nil {
-		panic(err)
-	}
+	return syscall.Setrlimit(syscall.RLIMIT_NOFILE, &rLimit)
 }
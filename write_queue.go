@@ -0,0 +1,159 @@
+package go_epoll
+
+const (
+	maxIovecs     = 1024     //一次writev最多的缓冲数量，即IOV_MAX
+	maxWritevSize = 32 << 10 //一次writev最多的字节数
+)
+
+// 队列中不能使用writev发送的部分，如文件与零拷贝发送的数据
+type sendFrame interface {
+	//在ET模式下发送，直到发送完或者EAGAIN，返回是否发送完，出错时返回的错误需要关闭连接
+	send(c *Conn) (bool, error)
+	//连接关闭时还没有发送完
+	abort(c *Conn, err error)
+}
+
+// 待发送数据队列，保存数据的切片，使用writev批量发送，不需要复制到连续的缓冲中
+// 发送文件等时在frames中放入nil占位，与数据按顺序发送
+type writeQueue struct {
+	frames [][]byte    //待发送的数据，第一个可能已经发送了一部分
+	others []sendFrame //不能使用writev发送的部分，与frames中的nil一一对应
+	size   int         //待发送的字节数，不包含文件
+	merge  int         //小于该值的数据合并到最后一个缓冲中，减少writev的缓冲数量
+}
+
+func newWriteQueue(merge int) *writeQueue {
+	return &writeQueue{
+		merge: merge,
+	}
+}
+
+// 待发送的字节数，不包含文件
+func (q *writeQueue) Len() int {
+	return q.size
+}
+
+// 数据与文件是否都已经发送完
+func (q *writeQueue) Empty() bool {
+	return len(q.frames) == 0
+}
+
+// 复制数据并加入队列，调用方在返回后可以继续使用p
+func (q *writeQueue) push(p []byte) {
+	if len(p) == 0 {
+		return
+	}
+	q.size += len(p)
+
+	//小数据合并到最后一个缓冲的剩余空间中
+	if n := len(q.frames); n > 0 && len(p) < q.merge {
+		last := q.frames[n-1]
+		if cap(last)-len(last) >= len(p) {
+			q.frames[n-1] = append(last, p...)
+			return
+		}
+	}
+
+	size := len(p)
+	if size < q.merge {
+		size = q.merge
+	}
+	b := make([]byte, len(p), size)
+	copy(b, p)
+	q.frames = append(q.frames, b)
+}
+
+// 不能使用writev发送的部分加入队列，size为计入待发送字节数的大小
+func (q *writeQueue) pushOther(f sendFrame, size int) {
+	q.frames = append(q.frames, nil)
+	q.others = append(q.others, f)
+	q.size += size
+}
+
+// 获取队列头部不能使用writev发送的部分，头部是数据时返回nil
+func (q *writeQueue) other() sendFrame {
+	if len(q.frames) == 0 || q.frames[0] != nil {
+		return nil
+	}
+	return q.others[0]
+}
+
+// 移除队列头部不能使用writev发送的部分
+func (q *writeQueue) popOther() {
+	q.frames[0] = nil
+	q.frames = q.frames[1:]
+	q.others[0] = nil
+	q.others = q.others[1:]
+}
+
+// 减少待发送的字节数
+func (q *writeQueue) consume(n int) {
+	q.size -= n
+}
+
+// 获取一次writev待发送的缓冲，最多maxIovecs个，超过maxWritevSize后不再增加，遇到文件时停止
+func (q *writeQueue) peek() [][]byte {
+	for i, f := range q.frames {
+		if f == nil {
+			return limitBuffers(q.frames[:i])
+		}
+	}
+	return limitBuffers(q.frames)
+}
+
+// 移除已经发送的n个字节
+func (q *writeQueue) advance(n int) {
+	q.size -= n
+	for n > 0 && len(q.frames) > 0 {
+		f := q.frames[0]
+		if f == nil {
+			break
+		}
+		if n < len(f) {
+			q.frames[0] = f[n:]
+			return
+		}
+		n -= len(f)
+		q.frames[0] = nil
+		q.frames = q.frames[1:]
+	}
+	if len(q.frames) == 0 {
+		q.frames = nil
+	}
+}
+
+// 清空，返回还没有发送完的部分
+func (q *writeQueue) reset() []sendFrame {
+	others := q.others
+	q.frames = nil
+	q.others = nil
+	q.size = 0
+	return others
+}
+
+// 限制一次writev的缓冲数量与字节数，内核发送缓冲较小时，一次提交太多数据反而会变慢
+func limitBuffers(bufs [][]byte) [][]byte {
+	size := 0
+	for i, b := range bufs {
+		if i == maxIovecs || size >= maxWritevSize {
+			return bufs[:i]
+		}
+		size += len(b)
+	}
+	return bufs
+}
+
+// 跳过bufs中前n个字节，返回剩余的缓冲
+func skipBuffers(bufs [][]byte, n int) [][]byte {
+	for n > 0 && len(bufs) > 0 {
+		if n < len(bufs[0]) {
+			rest := make([][]byte, len(bufs))
+			copy(rest, bufs)
+			rest[0] = rest[0][n:]
+			return rest
+		}
+		n -= len(bufs[0])
+		bufs = bufs[1:]
+	}
+	return bufs
+}
@@ -0,0 +1,227 @@
+package go_epoll
+
+import (
+	"bytes"
+	"testing"
+)
+
+// 测试用的占位发送部分
+type testFrame struct {
+	name string
+}
+
+func (f *testFrame) send(c *Conn) (bool, error) {
+	return true, nil
+}
+
+func (f *testFrame) abort(c *Conn, err error) {
+}
+
+// 取出队列中的全部数据，遇到文件时停止
+func queueData(q *writeQueue) []byte {
+	var b []byte
+	for _, f := range q.frames {
+		if f == nil {
+			break
+		}
+		b = append(b, f...)
+	}
+	return b
+}
+
+func TestWriteQueuePush(t *testing.T) {
+	tests := []struct {
+		name   string
+		merge  int
+		pushes []string
+		frames int
+	}{
+		{name: "empty", merge: 8, pushes: []string{"", ""}, frames: 0},
+		{name: "merge small", merge: 8, pushes: []string{"ab", "cd", "ef"}, frames: 1},
+		{name: "merge until full", merge: 4, pushes: []string{"ab", "cd", "ef"}, frames: 2},
+		{name: "large not merged", merge: 4, pushes: []string{"ab", "cdefgh", "ij"}, frames: 3},
+		{name: "large first", merge: 4, pushes: []string{"abcdef", "gh"}, frames: 2},
+		{name: "no merge", merge: 0, pushes: []string{"a", "b", "c"}, frames: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := newWriteQueue(tt.merge)
+			var want []byte
+			for _, p := range tt.pushes {
+				q.push([]byte(p))
+				want = append(want, p...)
+			}
+			if len(q.frames) != tt.frames {
+				t.Errorf("frames = %d, want %d", len(q.frames), tt.frames)
+			}
+			if q.Len() != len(want) {
+				t.Errorf("Len() = %d, want %d", q.Len(), len(want))
+			}
+			if q.Empty() != (len(want) == 0) {
+				t.Errorf("Empty() = %v", q.Empty())
+			}
+			if got := queueData(q); !bytes.Equal(got, want) {
+				t.Errorf("data = %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+// push复制数据，调用方修改p不影响队列
+func TestWriteQueuePushCopies(t *testing.T) {
+	q := newWriteQueue(8)
+	p := []byte("abc")
+	q.push(p)
+	q.push(p[:1])
+	p[0] = 'x'
+	if got := string(queueData(q)); got != "abca" {
+		t.Fatalf("data = %q", got)
+	}
+}
+
+func TestWriteQueueAdvance(t *testing.T) {
+	tests := []struct {
+		name    string
+		advance []int
+		data    string
+		frames  int
+	}{
+		{name: "nothing", advance: []int{0}, data: "abcdefghij", frames: 3},
+		{name: "inside first", advance: []int{2}, data: "cdefghij", frames: 3},
+		{name: "whole first", advance: []int{3}, data: "defghij", frames: 2},
+		{name: "across frames", advance: []int{5}, data: "fghij", frames: 2},
+		{name: "step by step", advance: []int{1, 1, 1, 1}, data: "efghij", frames: 2},
+		{name: "all", advance: []int{10}, data: "", frames: 0},
+		{name: "all in steps", advance: []int{4, 6}, data: "", frames: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := newWriteQueue(0)
+			for _, p := range []string{"abc", "defg", "hij"} {
+				q.push([]byte(p))
+			}
+			for _, n := range tt.advance {
+				q.advance(n)
+			}
+			if got := string(queueData(q)); got != tt.data {
+				t.Errorf("data = %q, want %q", got, tt.data)
+			}
+			if len(q.frames) != tt.frames {
+				t.Errorf("frames = %d, want %d", len(q.frames), tt.frames)
+			}
+			if q.Len() != len(tt.data) {
+				t.Errorf("Len() = %d, want %d", q.Len(), len(tt.data))
+			}
+			if q.Empty() != (tt.frames == 0) {
+				t.Errorf("Empty() = %v", q.Empty())
+			}
+		})
+	}
+}
+
+// 文件等与数据按顺序发送，peek与advance都在文件前停止
+func TestWriteQueueOther(t *testing.T) {
+	q := newWriteQueue(8)
+	f1, f2 := &testFrame{"f1"}, &testFrame{"f2"}
+	q.push([]byte("ab"))
+	q.pushOther(f1, 0)
+	q.push([]byte("cd"))
+	q.pushOther(f2, 5)
+
+	if q.Len() != 9 {
+		t.Fatalf("Len() = %d, want 9", q.Len())
+	}
+	if q.other() != nil {
+		t.Fatal("other() before data is sent")
+	}
+	if bufs := q.peek(); len(bufs) != 1 || string(bufs[0]) != "ab" {
+		t.Fatalf("peek() = %q", bufs)
+	}
+
+	q.advance(2)
+	if q.other() != f1 {
+		t.Fatalf("other() = %v, want f1", q.other())
+	}
+	if bufs := q.peek(); len(bufs) != 0 {
+		t.Fatalf("peek() before f1 = %q", bufs)
+	}
+	q.popOther()
+
+	//文件之后的小数据不会合并到文件之前的缓冲中
+	if bufs := q.peek(); len(bufs) != 1 || string(bufs[0]) != "cd" {
+		t.Fatalf("peek() = %q", bufs)
+	}
+	q.advance(2)
+	if q.other() != f2 {
+		t.Fatalf("other() = %v, want f2", q.other())
+	}
+	q.consume(5)
+	q.popOther()
+	if !q.Empty() || q.Len() != 0 {
+		t.Fatalf("Empty() = %v, Len() = %d", q.Empty(), q.Len())
+	}
+}
+
+func TestWriteQueueReset(t *testing.T) {
+	q := newWriteQueue(8)
+	f := &testFrame{"f"}
+	q.push([]byte("ab"))
+	q.pushOther(f, 3)
+	others := q.reset()
+	if len(others) != 1 || others[0] != f {
+		t.Fatalf("reset() = %v", others)
+	}
+	if !q.Empty() || q.Len() != 0 || q.other() != nil {
+		t.Fatalf("queue not empty after reset")
+	}
+}
+
+func TestLimitBuffers(t *testing.T) {
+	many := make([][]byte, maxIovecs+10)
+	for i := range many {
+		many[i] = []byte{1}
+	}
+	if got := len(limitBuffers(many)); got != maxIovecs {
+		t.Errorf("limit count = %d, want %d", got, maxIovecs)
+	}
+
+	big := [][]byte{make([]byte, maxWritevSize/2), make([]byte, maxWritevSize/2), {1}, {2}}
+	if got := len(limitBuffers(big)); got != 2 {
+		t.Errorf("limit size = %d, want 2", got)
+	}
+
+	//第一个缓冲超过大小限制时也要发送
+	huge := [][]byte{make([]byte, maxWritevSize*2), {1}}
+	if got := len(limitBuffers(huge)); got != 1 {
+		t.Errorf("limit huge = %d, want 1", got)
+	}
+}
+
+func TestSkipBuffers(t *testing.T) {
+	bufs := [][]byte{[]byte("abc"), []byte("de"), []byte("f")}
+	tests := []struct {
+		n    int
+		want []string
+	}{
+		{0, []string{"abc", "de", "f"}},
+		{1, []string{"bc", "de", "f"}},
+		{3, []string{"de", "f"}},
+		{4, []string{"e", "f"}},
+		{6, nil},
+	}
+	for _, tt := range tests {
+		got := skipBuffers(bufs, tt.n)
+		if len(got) != len(tt.want) {
+			t.Fatalf("skip %d = %q, want %q", tt.n, got, tt.want)
+		}
+		for i := range got {
+			if string(got[i]) != tt.want[i] {
+				t.Fatalf("skip %d = %q, want %q", tt.n, got, tt.want)
+			}
+		}
+	}
+	//不修改原来的缓冲列表
+	if string(bufs[0]) != "abc" {
+		t.Fatalf("bufs modified: %q", bufs)
+	}
+}